@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ianb-mp/ec2-github-runner/runner"
+)
+
+func main() {
+
+	// Cancelling the command, e.g. with Ctrl-C or when a CI job times out, stops waiting and releases what the
+	// command has started.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	code := runner.RunCLI(ctx, os.Args[1:], os.Stdout, os.Stderr, os.Getenv, runner.ConnectAWS)
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,447 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// cleanupTimeout bounds the time spent releasing resources after the workflow has been cancelled,
+// which must fit within the grace period the runner allows before killing the container.
+const cleanupTimeout = 5 * time.Second
+
+// defaultInstanceType is the instance type launched when none is given.
+const defaultInstanceType = "t3.micro"
+
+// RunAction runs the mode given by the inputs of the GitHub Action, setting its outputs. Cancelling ctx, e.g. when
+// the workflow is cancelled, stops waiting and releases what the mode has started.
+func RunAction(ctx context.Context, action *githubactions.Action) (err error) {
+
+	// The inputs that configure the AWS clients are parsed first, since they may be needed to read the profile
+	// config. Their problems are reported with the other inputs'.
+	awsIn, awsErr := parseAWSInputs(action)
+	endpoints := awsIn.Endpoints
+	workspace := action.Getenv("GITHUB_WORKSPACE")
+	if workspace == "" {
+		workspace = "."
+	}
+
+	// Calls through the STS, S3 and SSM parameter interfaces are retried by awsIn.RetryPolicy instead of the SDK's
+	// retryer, like those of the other clients below.
+	newSTSClient := func(cfg aws.Config) STSAPI {
+		return NewRetryingSTSClient(action, sts.NewFromConfig(cfg, func(o *sts.Options) {
+			o.Retryer = aws.NopRetryer{}
+			endpoints.apply("sts", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		}), awsIn.RetryPolicy)
+	}
+	newS3Client := func(cfg aws.Config) *s3.Client {
+		return s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.Retryer = aws.NopRetryer{}
+			o.UsePathStyle = awsIn.S3UsePathStyle
+			endpoints.apply("s3", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	// The AWS config is loaded when it is first needed, which may be to read the profile config, so that
+	// print-iam-policy doesn't need credentials.
+	loadAWSConfig := sync.OnceValues(func() (aws.Config, error) {
+		if awsErr != nil {
+			return aws.Config{}, awsErr
+		}
+		var configOptions []func(*config.LoadOptions) error
+		if awsIn.Region != "" {
+			configOptions = append(configOptions, config.WithRegion(awsIn.Region))
+		}
+		cfg, err := config.LoadDefaultConfig(ctx, configOptions...)
+		if err != nil {
+			return cfg, err
+		}
+		if awsIn.RoleToAssume != "" {
+			cfg.Credentials = NewWebIdentityCredentials(action, action, action.Getenv, newSTSClient(cfg), awsIn.RoleToAssume, awsIn.Audience, awsIn.RoleDurationSecs)
+			// Assume the role now, so a misconfigured trust policy fails before any other call.
+			if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+				return cfg, err
+			}
+		}
+		if len(awsIn.AssumeRoleArns) > 0 {
+			cfg, err = AssumeRoleChain(ctx, action, action.Getenv, cfg, newSTSClient, awsIn.AssumeRoleArns, awsIn.AssumeRoleExternalId, awsIn.AssumeRoleDurationSecs, awsIn.AssumeRoleSessionTagging)
+			if err != nil {
+				return cfg, err
+			}
+		}
+		return cfg, nil
+	})
+
+	// profileConfig is where the profile is read from, if one is used.
+	var profileConfig string
+	if profile := action.GetInput("profile"); profile != "" {
+		profileConfig = action.GetInput("profile-config")
+		if profileConfig == "" {
+			profileConfig = defaultProfileConfig
+		}
+		profiles, err := LoadProfileConfig(ctx, profileConfig, workspace, func() (S3API, SSMParameterAPI, error) {
+			cfg, err := loadAWSConfig()
+			if err != nil {
+				return nil, nil, err
+			}
+			ssmClient := ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+				o.Retryer = aws.NopRetryer{}
+				endpoints.apply("ssm", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+			})
+			return NewRetryingS3Client(action, newS3Client(cfg), awsIn.RetryPolicy), NewRetryingSSMParameterClient(action, ssmClient, awsIn.RetryPolicy), nil
+		})
+		if err != nil {
+			return err
+		}
+		inputs, err := profiles.Inputs(profile)
+		if err != nil {
+			return err
+		}
+		action.Infof("Using profile %s from %s", profile, profileConfig)
+		action = withProfileInputs(action, inputs)
+	}
+
+	in, err := parseInputs(action)
+	if err != nil {
+		return err
+	}
+	mode := in.Mode
+
+	// print-iam-policy makes no AWS calls, so it doesn't need credentials.
+	if mode == "print-iam-policy" {
+		awsRegion := in.AWS.Region
+		if awsRegion == "" {
+			awsRegion = action.Getenv("AWS_REGION")
+		}
+		targets, err := ParseRegionTargets(in.Regions, RegionTarget{Region: awsRegion, ImageId: in.ImageId, SubnetId: in.SubnetId, SecurityGroupId: in.SecurityGroupId})
+		if err != nil {
+			return fmt.Errorf("Invalid value for 'regions': %v", err)
+		}
+		policy := BuildIAMPolicy(PolicyOptions{
+			Modes:               in.PolicyModes,
+			Partition:           regionPartition(targets[0].Region),
+			Account:             orWildcard(in.PolicyAccountId),
+			Targets:             targets,
+			InstanceTags:        resourceTags(in.TagSpecifications, ec2Types.ResourceTypeInstance),
+			VolumeTags:          resourceTags(in.TagSpecifications, ec2Types.ResourceTypeVolume),
+			IAMRoleName:         in.IAMRoleName,
+			CreateIAMRole:       in.CreateIAMRole,
+			RolePolicyArns:      in.IAMRole.PolicyArns,
+			PermissionsBoundary: in.IAMRole.PermissionsBoundary,
+			Readiness:           in.Readiness,
+			TerminateOnCancel:   in.TerminateOnCancel,
+			SkipPreflight:       in.SkipPreflight,
+			S3Bucket:            in.S3Bucket,
+			S3KeyPrefix:         in.S3KeyPrefix,
+			DecodeAuthorization: in.DecodeAuthorization,
+			ProfileConfig:       profileConfig,
+			ProfileConfigRegion: awsRegion,
+		})
+		document, err := json.MarshalIndent(policy, "", "  ")
+		if err != nil {
+			return err
+		}
+		action.Group("IAM policy")
+		fmt.Println(string(document))
+		action.EndGroup()
+		setMultilineOutput(action, action, "iam-policy", string(document))
+		return nil
+	}
+
+	cfg, err := loadAWSConfig()
+	if err != nil {
+		return err
+	}
+
+	// The RunnerManager and the clients used directly retry their calls by in.AWS.RetryPolicy instead of the SDK's
+	// retryer. EC2 and SSM clients for other regions are created when start falls back to them.
+	regionEC2Client := func(region string) *ec2.Client {
+		return ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+			o.Region = region
+			o.Retryer = aws.NopRetryer{}
+			endpoints.apply("ec2", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	regionSSMClient := func(region string) *ssm.Client {
+		return ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+			o.Region = region
+			o.Retryer = aws.NopRetryer{}
+			endpoints.apply("ssm", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+		})
+	}
+	unwrappedIAMClient := iam.NewFromConfig(cfg, func(o *iam.Options) {
+		o.Retryer = aws.NopRetryer{}
+		endpoints.apply("iam", &o.BaseEndpoint, &o.EndpointOptions.UseFIPSEndpoint, &o.EndpointOptions.UseDualStackEndpoint)
+	})
+	newEC2Client := func(region string) EC2API {
+		return NewRetryingEC2Client(action, regionEC2Client(region), in.AWS.RetryPolicy)
+	}
+	newManager := func(region string) (*RunnerManager, error) {
+		return NewRunnerManager(
+			WithEC2Client(regionEC2Client(region)),
+			WithSSMClient(regionSSMClient(region)),
+			WithIAMClient(unwrappedIAMClient),
+			WithLogger(action),
+			WithRetryPolicy(in.AWS.RetryPolicy),
+			WithClock(clockFrom(ctx)),
+		)
+	}
+	manager, err := newManager(cfg.Region)
+	if err != nil {
+		return err
+	}
+	ec2Client := newEC2Client(cfg.Region)
+	iamClient := NewRetryingIAMClient(action, unwrappedIAMClient, in.AWS.RetryPolicy)
+	ssmClient := NewRetryingSSMClient(action, regionSSMClient(cfg.Region), in.AWS.RetryPolicy)
+	stsClient := newSTSClient(cfg)
+	if in.DecodeAuthorization {
+		defer func() { err = DecodeAuthorizationError(ctx, action, stsClient, err) }()
+	}
+	// Presigning doesn't call S3, so the presign client is created from the client that isn't wrapped.
+	unwrappedS3Client := newS3Client(cfg)
+	s3Client := NewRetryingS3Client(action, unwrappedS3Client, in.AWS.RetryPolicy)
+	s3PresignClient := s3.NewPresignClient(unwrappedS3Client)
+
+	switch mode {
+	case "start":
+		targets, err := ParseRegionTargets(in.Regions, RegionTarget{Region: cfg.Region, ImageId: in.ImageId, SubnetId: in.SubnetId, SecurityGroupId: in.SecurityGroupId})
+		if err != nil {
+			return fmt.Errorf("Invalid value for 'regions': %v", err)
+		}
+		spec := LaunchSpec{
+			InstanceType:      in.InstanceType,
+			IAMRoleName:       in.IAMRoleName,
+			CreateIAMRole:     in.CreateIAMRole,
+			IAMRole:           in.IAMRole,
+			UserData:          in.UserData,
+			TagSpecifications: in.TagSpecifications,
+			Readiness:         in.Readiness,
+			SkipPreflight:     in.SkipPreflight,
+		}
+		if in.DryRun {
+			var problems []string
+			if !in.SkipPreflight {
+				for _, target := range targets {
+					problems = append(problems, PreflightStart(ctx, action, newEC2Client(target.Region), target, in.InstanceType)...)
+				}
+			}
+			problems = append(problems, DryRunStart(ctx, action, newEC2Client, iamClient, targets, spec)...)
+			problems = append(problems, CheckPermissions(ctx, action, iamClient, stsClient, startPermissions(in.Readiness, in.IAMRoleName, in.CreateIAMRole, in.TerminateOnCancel))...)
+			return ReportDryRun(action, mode, problems)
+		}
+		// Start runs the preflight checks in each region before launching in it, so a problem in a fallback region
+		// doesn't block launching in the first region.
+		var started *StartResult
+		target, instanceId, err := StartInRegions(ctx, action, targets, func(target RegionTarget) (string, error) {
+			if manager, err = newManager(target.Region); err != nil {
+				return "", err
+			}
+			ec2Client = newEC2Client(target.Region)
+			spec.ImageId, spec.SubnetId, spec.SecurityGroupId = target.ImageId, target.SubnetId, target.SecurityGroupId
+			result, err := manager.Start(ctx, spec)
+			if result == nil {
+				return "", err
+			}
+			started = result
+			return result.InstanceId, err
+		})
+		if instanceId != "" {
+			action.SetOutput("region", target.Region)
+			if in.JobSummary && started != nil {
+				addStepSummary(action, startSummary(started, target.Region, in.HourlyPrice))
+			}
+		}
+		if err != nil {
+			if instanceId != "" && ctx.Err() == nil {
+				collectDiagnostics(ctx, action, action, ec2Client, instanceId, workspace, in.DiagnosticsDirectory)
+			}
+			if instanceId != "" {
+				if ctx.Err() != nil && in.TerminateOnCancel {
+					terminateLaunchedInstance(action, manager, instanceId)
+				} else {
+					// Set the output so a later stop step can still terminate the instance.
+					action.Warningf("Instance %s was launched but is not ready; it has been left running.", instanceId)
+					action.SetOutput("ec2-instance-id", instanceId)
+				}
+			}
+			return fmt.Errorf("Error occurred: %w", err)
+		}
+		action.Infof("Started EC2 instance with ID: %s in region %s", instanceId, target.Region)
+		action.SetOutput("ec2-instance-id", instanceId)
+
+	case "command":
+		if in.DryRun {
+			problems := DryRunInstanceCommand(ctx, action, ssmClient, in.InstanceId)
+			problems = append(problems, CheckPermissions(ctx, action, iamClient, stsClient, modePermissions[mode])...)
+			return ReportDryRun(action, mode, problems)
+		}
+		result, err := manager.RunCommand(ctx, CommandSpec{
+			InstanceId:      in.InstanceId,
+			Command:         in.Command,
+			Timeout:         time.Duration(in.CommandMaxWaitSecs) * time.Second,
+			SSMAgentTimeout: time.Duration(in.SSMAgentMaxWaitSecs) * time.Second,
+			NoWait:          in.CommandAsync,
+		})
+		if result == nil {
+			return err
+		}
+		if in.CommandAsync {
+			action.Infof("Command '%s' sent to instance %s. Command ID: %s. Use 'wait-command' mode to collect the result.", in.Command, in.InstanceId, result.CommandId)
+			action.SetOutput("command-id", result.CommandId)
+			if in.JobSummary {
+				addStepSummary(action, commandSummary(mode, in.InstanceId, []commandSummaryRow{{CommandId: result.CommandId, Status: "Sent", ExitCode: -1}}))
+			}
+			break
+		}
+		if result.Status != "" {
+			SetCommandOutputs(action, action, result, in.ParseOutputs)
+			if in.JobSummary {
+				addStepSummary(action, commandSummary(mode, in.InstanceId, []commandSummaryRow{resultSummaryRow(result)}))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		action.Infof("Command '%s' sent to instance %s. Command ID: %s. Command wait time: %d secs", in.Command, in.InstanceId, result.CommandId, in.CommandMaxWaitSecs)
+		action.SetOutput("command-id", result.CommandId)
+
+	case "wait-command":
+		if in.DryRun {
+			return ReportDryRun(action, mode, CheckPermissions(ctx, action, iamClient, stsClient, modePermissions[mode]))
+		}
+		result, err := manager.WaitCommand(ctx, in.InstanceId, in.CommandId, time.Duration(in.CommandMaxWaitSecs)*time.Second)
+		if result.Status != "" {
+			SetCommandOutputs(action, action, result, in.ParseOutputs)
+			if in.JobSummary {
+				addStepSummary(action, commandSummary(mode, in.InstanceId, []commandSummaryRow{resultSummaryRow(result)}))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		action.Infof("Command %s completed on instance %s.", in.CommandId, in.InstanceId)
+		action.SetOutput("command-id", in.CommandId)
+
+	case "copy-to-instance":
+		if in.DryRun {
+			problems := DryRunCopyToInstance(action, in.LocalPaths, workspace)
+			problems = append(problems, DryRunInstanceCommand(ctx, action, ssmClient, in.InstanceId)...)
+			problems = append(problems, CheckPermissions(ctx, action, iamClient, stsClient, modePermissions[mode])...)
+			return ReportDryRun(action, mode, problems)
+		}
+		commandId, err := CopyToEC2Instance(ctx, action, ssmClient, s3Client, s3PresignClient, in.InstanceId, in.LocalPaths, workspace, in.S3Bucket, in.S3KeyPrefix, in.RemoteDirectory, in.SSMAgentMaxWaitSecs, in.CommandMaxWaitSecs)
+		if in.JobSummary && commandId != "" {
+			addStepSummary(action, commandSummary(mode, in.InstanceId, []commandSummaryRow{copySummaryRow(commandId, err)}))
+		}
+		if err != nil {
+			if ctx.Err() != nil && commandId != "" {
+				cancelOutstandingCommand(action, ssmClient, in.InstanceId, commandId)
+			}
+			return err
+		}
+		action.SetOutput("command-id", commandId)
+
+	case "copy-from-instance":
+		if in.DryRun {
+			problems := DryRunInstanceCommand(ctx, action, ssmClient, in.InstanceId)
+			problems = append(problems, CheckPermissions(ctx, action, iamClient, stsClient, modePermissions[mode])...)
+			return ReportDryRun(action, mode, problems)
+		}
+		commandId, err := CopyFromEC2Instance(ctx, action, ssmClient, s3Client, s3PresignClient, in.InstanceId, in.RemotePaths, in.OptionalRemotePaths, filepath.Join(workspace, in.LocalDirectory), in.S3Bucket, in.S3KeyPrefix, in.SSMAgentMaxWaitSecs, in.CommandMaxWaitSecs)
+		if in.JobSummary && commandId != "" {
+			addStepSummary(action, commandSummary(mode, in.InstanceId, []commandSummaryRow{copySummaryRow(commandId, err)}))
+		}
+		if err != nil {
+			if ctx.Err() != nil && commandId != "" {
+				cancelOutstandingCommand(action, ssmClient, in.InstanceId, commandId)
+			}
+			return err
+		}
+		action.SetOutput("command-id", commandId)
+
+	case "stop":
+		if in.DryRun {
+			return ReportDryRun(action, mode, DryRunTerminate(ctx, action, ec2Client, in.InstanceId))
+		}
+		// Describe the instance before it's terminated for the summary, which isn't worth failing the step for.
+		var instance *ec2Types.Instance
+		if in.JobSummary {
+			var describeErr error
+			if instance, describeErr = manager.Describe(ctx, in.InstanceId); describeErr != nil {
+				action.Warningf("Could not describe instance %s for the job summary: %v", in.InstanceId, describeErr)
+			}
+		}
+		result, err := manager.Stop(ctx, in.InstanceId)
+		if err != nil {
+			return err
+		}
+		if instance != nil {
+			addStepSummary(action, stopSummary(*instance, cfg.Region, in.HourlyPrice, result.StoppedAt))
+		}
+
+	}
+	return nil
+}
+
+// withProfileInputs returns an Action whose inputs that aren't set are taken from the inputs of a profile.
+func withProfileInputs(action *githubactions.Action, inputs map[string]string) *githubactions.Action {
+	env := map[string]string{}
+	for name, value := range inputs {
+		env["INPUT_"+strings.ToUpper(name)] = value
+	}
+	return githubactions.New(githubactions.WithGetenv(func(key string) string {
+		if v := action.Getenv(key); strings.TrimSpace(v) != "" {
+			return v
+		}
+		return env[key]
+	}))
+}
+
+// cancelOutstandingCommand cancels an outstanding SSM command after the workflow has been cancelled.
+func cancelOutstandingCommand(logger Logger, ssmClient SSMAPI, ec2InstanceId string, commandId CommandId) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	logger.Warningf("Cancelled, cancelling command %s on instance %s", commandId, ec2InstanceId)
+	if err := CancelCommand(ctx, logger, ssmClient, ec2InstanceId, commandId); err != nil {
+		logger.Errorf("%s", err)
+	}
+}
+
+// collectDiagnostics saves the console output and screenshot of an instance that failed to become ready into dir
+// within the workspace, and sets the diagnostics-directory output if anything was saved. The output is relative to
+// the workspace, since the workspace is mounted at a different path inside the action's container.
+func collectDiagnostics(ctx context.Context, logger Logger, reporter Reporter, ec2Client EC2API, ec2InstanceId, workspace, dir string) {
+	saved, err := CollectInstanceDiagnostics(ctx, logger, ec2Client, ec2InstanceId, filepath.Join(workspace, dir))
+	if err != nil {
+		logger.Warningf("%s", err)
+		return
+	}
+	if len(saved) > 0 {
+		setMultilineOutput(logger, reporter, "diagnostics-directory", dir)
+	}
+}
+
+// terminateLaunchedInstance terminates an instance launched by this step after the workflow has been cancelled.
+func terminateLaunchedInstance(logger Logger, manager *RunnerManager, ec2InstanceId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	logger.Warningf("Workflow cancelled, terminating instance %s", ec2InstanceId)
+	if _, err := manager.Stop(ctx, ec2InstanceId); err != nil {
+		logger.Errorf("%s", err)
+	}
+}
@@ -0,0 +1,483 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+)
+
+// instanceProfilePropagationTimeout is the number of seconds to wait for a newly created instance profile
+// to be usable by RunInstances.
+const instanceProfilePropagationTimeout = 120
+
+// CreateAndStartEC2Instance launches an EC2 instance as described by spec and waits for it to meet each of the
+// readiness conditions. The instance profile of spec.IAMRoleName is created if the role doesn't have one, but the
+// role itself must exist. If the instance was launched, the result is returned even if it didn't become ready, so
+// the caller can clean it up.
+func CreateAndStartEC2Instance(ctx context.Context, logger Logger, ec2Client EC2API, ssmClient SSMAPI, iamClient IAMAPI, spec LaunchSpec) (*StartResult, error) {
+	startParams := newRunInstancesInput(spec)
+
+	if spec.IAMRoleName != "" {
+		instanceProfileName, err := GetOrCreateInstanceProfile(ctx, logger, iamClient, spec.IAMRoleName)
+		if err != nil {
+			return nil, fmt.Errorf("error creating or retrieving instance profile for IAM role name %s: %w", spec.IAMRoleName, err)
+		}
+		startParams.IamInstanceProfile = &ec2Types.IamInstanceProfileSpecification{Name: aws.String(instanceProfileName)}
+	}
+
+	runResult, err := RunInstancesWithProfileRetry(ctx, logger, ec2Client, startParams, instanceProfilePropagationTimeout, 5)
+	if err != nil {
+		return nil, fmt.Errorf("error starting EC2 instance: %w", err)
+	}
+	result := &StartResult{
+		InstanceId: aws.ToString(runResult.Instances[0].InstanceId),
+		Instance:   runResult.Instances[0],
+		LaunchedAt: now(ctx),
+	}
+
+	result.Phases, err = WaitForInstanceReady(ctx, logger, ec2Client, ssmClient, result.InstanceId, spec.Readiness)
+	if err != nil {
+		return result, fmt.Errorf("error waiting for instance to be ready: %w", err)
+	}
+	result.ReadyAt = now(ctx)
+
+	// Addresses and other details are only known once the instance is running.
+	if instance, err := DescribeEC2Instance(ctx, ec2Client, result.InstanceId); err != nil {
+		logger.Warningf("Could not describe instance %s: %v", result.InstanceId, err)
+	} else {
+		result.Instance = *instance
+	}
+	return result, nil
+}
+
+// WaitForInstanceRunning waits for the specified EC2 instance to reach the "running" state.
+// It checks the instance state every interval seconds using the provided EC2 client until the instance is running.
+// The function returns an error if there is an issue describing the instance, if the instance enters a state from
+// which it can't become running (e.g. "terminated"), if it isn't running within timeout seconds, or if ctx is done.
+func WaitForInstanceRunning(ctx context.Context, logger Logger, ec2Client EC2API, instanceId string, timeout, interval int) error {
+	endTime := now(ctx).Add(time.Duration(timeout) * time.Second)
+
+	params := &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceId},
+	}
+
+	instanceState := ec2Types.InstanceStateNamePending
+	for {
+		resp, err := ec2Client.DescribeInstances(ctx, params)
+		switch {
+		case isErrorCode(err, "InvalidInstanceID.NotFound"):
+			// A newly launched instance may not be visible to DescribeInstances straight away.
+			logger.Infof("Instance %s not found yet. Waiting...", instanceId)
+		case err != nil:
+			return fmt.Errorf("error describing instance %s: %w", instanceId, err)
+		case len(resp.Reservations) == 0 || len(resp.Reservations[0].Instances) == 0:
+			logger.Infof("Instance %s not found yet. Waiting...", instanceId)
+		default:
+			instance := resp.Reservations[0].Instances[0]
+			instanceState = instance.State.Name
+			logger.Infof("Instance state: %s", instanceState)
+			switch instanceState {
+			case ec2Types.InstanceStateNameRunning:
+				logger.Infof("Instance %s is now running.", instanceId)
+				return nil
+			case ec2Types.InstanceStateNameShuttingDown, ec2Types.InstanceStateNameTerminated, ec2Types.InstanceStateNameStopping, ec2Types.InstanceStateNameStopped:
+				return fmt.Errorf("instance %s entered state %s%s", instanceId, instanceState, describeStateReason(instance))
+			}
+		}
+
+		if !now(ctx).Before(endTime) {
+			return fmt.Errorf("timed out after %d seconds waiting for instance %s to be running (last state: %s)", timeout, instanceId, instanceState)
+		}
+		if err := sleepContext(ctx, time.Duration(interval)*time.Second); err != nil {
+			return fmt.Errorf("stopped waiting for instance %s to be running: %w", instanceId, err)
+		}
+	}
+}
+
+// newRunInstancesInput returns the RunInstances parameters for launching a single instance as described by spec.
+// A new client token is generated for each call.
+func newRunInstancesInput(spec LaunchSpec) *ec2.RunInstancesInput {
+	return &ec2.RunInstancesInput{
+		ImageId:      aws.String(spec.ImageId),
+		InstanceType: ec2Types.InstanceType(spec.InstanceType),
+		// The client token makes retrying RunInstances after a transient error safe, as EC2 won't launch a second instance.
+		ClientToken:       aws.String(newClientToken()),
+		MaxCount:          aws.Int32(1),
+		MinCount:          aws.Int32(1),
+		Monitoring:        &ec2Types.RunInstancesMonitoringEnabled{Enabled: aws.Bool(false)},
+		SubnetId:          aws.String(spec.SubnetId),
+		SecurityGroupIds:  []string{spec.SecurityGroupId},
+		UserData:          aws.String(base64.StdEncoding.EncodeToString([]byte(spec.UserData))),
+		TagSpecifications: spec.TagSpecifications,
+	}
+}
+
+// ParseTagSpecifications parses a JSON array of EC2 tag specifications, as taken by the tag-specifications input.
+func ParseTagSpecifications(tagSpecifications string) ([]ec2Types.TagSpecification, error) {
+	if tagSpecifications == "" {
+		return nil, nil
+	}
+	var tags []ec2Types.TagSpecification
+	if err := json.Unmarshal([]byte(tagSpecifications), &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// describeStateReason returns the reason an instance changed state, formatted for appending to an error message.
+func describeStateReason(instance ec2Types.Instance) string {
+	if instance.StateReason != nil {
+		return fmt.Sprintf(": %s: %s", aws.ToString(instance.StateReason.Code), aws.ToString(instance.StateReason.Message))
+	}
+	if reason := aws.ToString(instance.StateTransitionReason); reason != "" {
+		return ": " + reason
+	}
+	return ""
+}
+
+// GetOrCreateInstanceProfile retrieves an existing instance profile with the specified IAM role name,
+// or creates a new instance profile if it doesn't exist. It returns the name of the instance profile
+// and any error encountered during the process. Creating the profile tolerates another job creating
+// the same profile concurrently.
+func GetOrCreateInstanceProfile(ctx context.Context, logger Logger, iamClient IAMAPI, iamRoleName string) (string, error) {
+	instanceProfileName, err := findInstanceProfile(ctx, iamClient, iamRoleName)
+	if err != nil {
+		return "", err
+	}
+	if instanceProfileName != "" {
+		logger.Infof("Instance profile for IAM role %s already exists.", iamRoleName)
+		return instanceProfileName, nil
+	}
+
+	createProfileInput := &iam.CreateInstanceProfileInput{
+		InstanceProfileName: aws.String(iamRoleName),
+	}
+	_, err = iamClient.CreateInstanceProfile(ctx, createProfileInput)
+	switch {
+	case isErrorCode(err, "EntityAlreadyExists"):
+		// Left behind by an earlier run, or being created by a concurrent job; make sure it has the role.
+		logger.Infof("Instance profile %s already exists", iamRoleName)
+	case err != nil:
+		return "", fmt.Errorf("error creating instance profile: %w", err)
+	default:
+		logger.Infof("Created instance profile %s", iamRoleName)
+	}
+
+	attachRoleInput := &iam.AddRoleToInstanceProfileInput{
+		InstanceProfileName: aws.String(iamRoleName),
+		RoleName:            aws.String(iamRoleName),
+	}
+	_, err = iamClient.AddRoleToInstanceProfile(ctx, attachRoleInput)
+	if isErrorCode(err, "LimitExceeded") {
+		// An instance profile holds a single role, which may already be this one.
+		attached, gerr := instanceProfileHasRole(ctx, iamClient, iamRoleName, iamRoleName)
+		if gerr != nil {
+			return "", gerr
+		}
+		if !attached {
+			return "", fmt.Errorf("instance profile %s already exists with a different role", iamRoleName)
+		}
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error attaching role to instance profile: %w", err)
+	}
+	logger.Infof("Attached role %s to instance profile %s", iamRoleName, iamRoleName)
+
+	return iamRoleName, nil
+}
+
+// findInstanceProfile returns the name of an instance profile containing the named role, or "" if there is none.
+func findInstanceProfile(ctx context.Context, iamClient IAMAPI, iamRoleName string) (string, error) {
+	listProfilesInput := &iam.ListInstanceProfilesForRoleInput{
+		RoleName: aws.String(iamRoleName),
+	}
+	paginator := iam.NewListInstanceProfilesForRolePaginator(iamClient, listProfilesInput)
+	for paginator.HasMorePages() {
+		profiles, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error listing instance profiles for role %s: %w", iamRoleName, err)
+		}
+		if len(profiles.InstanceProfiles) > 0 {
+			return *profiles.InstanceProfiles[0].InstanceProfileName, nil
+		}
+	}
+	return "", nil
+}
+
+// instanceProfileHasRole reports whether the named instance profile contains the named role.
+func instanceProfileHasRole(ctx context.Context, iamClient IAMAPI, instanceProfileName, iamRoleName string) (bool, error) {
+	resp, err := iamClient.GetInstanceProfile(ctx, &iam.GetInstanceProfileInput{
+		InstanceProfileName: aws.String(instanceProfileName),
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting instance profile %s: %w", instanceProfileName, err)
+	}
+	for _, role := range resp.InstanceProfile.Roles {
+		if aws.ToString(role.RoleName) == iamRoleName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RunInstancesWithProfileRetry launches an instance, retrying every interval seconds for up to timeout seconds
+// while EC2 rejects its instance profile because a newly created profile hasn't propagated through IAM yet.
+func RunInstancesWithProfileRetry(ctx context.Context, logger Logger, ec2Client EC2API, params *ec2.RunInstancesInput, timeout, interval int) (*ec2.RunInstancesOutput, error) {
+	endTime := now(ctx).Add(time.Duration(timeout) * time.Second)
+
+	for {
+		runResult, err := ec2Client.RunInstances(ctx, params)
+		if err == nil || params.IamInstanceProfile == nil || !isInstanceProfilePropagationError(err) || !now(ctx).Before(endTime) {
+			return runResult, err
+		}
+
+		logger.Infof("Instance profile %s is not usable yet, waiting for IAM propagation...", aws.ToString(params.IamInstanceProfile.Name))
+		if err := sleepContext(ctx, time.Duration(interval)*time.Second); err != nil {
+			return nil, err
+		}
+		// The rejected request must not be matched with the next attempt.
+		params.ClientToken = aws.String(newClientToken())
+	}
+}
+
+// isInstanceProfilePropagationError reports whether RunInstances rejected an instance profile that doesn't
+// exist yet as far as EC2 can tell.
+func isInstanceProfilePropagationError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidParameterValue" && strings.Contains(apiErr.ErrorMessage(), "Invalid IAM Instance Profile")
+}
+
+type CommandId = string
+
+// defaultSSMAgentMaxWaitTime is the number of seconds to wait for the SSM agent to come online before sending a
+// command, unless another time is given.
+const defaultSSMAgentMaxWaitTime = 60
+
+// ssmAgentPollInterval is the number of seconds between checks of whether the SSM agent is online.
+const ssmAgentPollInterval = 5
+
+// ExecuteCommandOnEC2Instance executes a command on an EC2 instance using the AWS Systems Manager (SSM) service.
+// It waits up to ssmAgentMaxWaitTime seconds for the SSM agent to be online and commandMaxWaitTime seconds for the
+// command to complete, and returns the command ID, the command invocation details and an error (if any). If the command was sent but waiting for it failed, its ID is returned
+// together with the error so the caller can cancel it, along with the invocation details if the command finished.
+func ExecuteCommandOnEC2Instance(ctx context.Context, logger Logger, ssmClient SSMAPI, ec2InstanceId, command string, ssmAgentMaxWaitTime, commandMaxWaitTime int) (CommandId, *ssm.GetCommandInvocationOutput, error) {
+	commandId, err := SendCommandToEC2Instance(ctx, logger, ssmClient, ec2InstanceId, command, ssmAgentMaxWaitTime)
+	if err != nil {
+		return "", nil, err
+	}
+
+	commandInvocationDetails, err := WaitForCommand(ctx, logger, ssmClient, ec2InstanceId, commandId, commandMaxWaitTime)
+	if err != nil {
+		return commandId, commandInvocationDetails, err
+	}
+
+	return commandId, commandInvocationDetails, nil
+}
+
+// SendCommandToEC2Instance sends a command to an EC2 instance using the AWS Systems Manager (SSM) service
+// once its SSM agent is online, waiting up to ssmAgentMaxWaitTime seconds for it, without waiting for the command to
+// complete. It returns the command ID and an error (if any).
+func SendCommandToEC2Instance(ctx context.Context, logger Logger, ssmClient SSMAPI, ec2InstanceId, command string, ssmAgentMaxWaitTime int) (CommandId, error) {
+	// Instances started with wait-for: ssm-online are already registered, so this only guards against
+	// sending a command to an instance that was started without waiting for SSM.
+	logger.Infof("Waiting up to %d seconds for SSM agent on instance %s", ssmAgentMaxWaitTime, ec2InstanceId)
+	reg, err := IsSSMAgentRegistered(ctx, logger, ssmClient, ec2InstanceId, ssmAgentMaxWaitTime, ssmAgentPollInterval)
+	if err != nil {
+		return "", err
+	}
+	if !reg {
+		return "", fmt.Errorf("SSM agent is not registered or online for instance %s after %d seconds", ec2InstanceId, ssmAgentMaxWaitTime)
+	}
+
+	sendCommandInput := &ssm.SendCommandInput{
+		InstanceIds:  []string{ec2InstanceId},
+		DocumentName: aws.String("AWS-RunShellScript"),
+		Parameters: map[string][]string{
+			"commands": {command},
+		},
+	}
+
+	sendCommandResp, err := ssmClient.SendCommand(ctx, sendCommandInput)
+	if err != nil {
+		return "", fmt.Errorf("error sending command '%s' to EC2 instance %s: %w", command, ec2InstanceId, err)
+	}
+
+	return CommandId(*sendCommandResp.Command.CommandId), nil
+}
+
+// WaitForCommand waits up to maxWaitTime seconds for a previously sent command to complete on an EC2 instance
+// and prints the command invocation details. It returns the command invocation details and an error (if any).
+// If the command finished unsuccessfully, the details are returned together with an error containing its standard error.
+func WaitForCommand(ctx context.Context, logger Logger, ssmClient SSMAPI, ec2InstanceId string, commandId CommandId, maxWaitTime int) (*ssm.GetCommandInvocationOutput, error) {
+	commandInvocationDetails, err := GetCommandInvocationDetails(ctx, logger, ssmClient, ec2InstanceId, commandId, maxWaitTime)
+	if err != nil {
+		// The waiter discards the invocation when the command fails, so fetch it again to report why.
+		if ctx.Err() == nil {
+			details, derr := ssmClient.GetCommandInvocation(ctx, &ssm.GetCommandInvocationInput{
+				CommandId:  aws.String(commandId),
+				InstanceId: aws.String(ec2InstanceId),
+			})
+			if derr == nil && isCommandFinished(details.Status) {
+				printCommandInvocationDetails(logger, details)
+				return details, fmt.Errorf("command %s finished with status %s: %s", commandId, details.Status, strings.TrimSpace(aws.ToString(details.StandardErrorContent)))
+			}
+		}
+		return nil, fmt.Errorf("error getting command invocation details: %w", err)
+	}
+
+	printCommandInvocationDetails(logger, commandInvocationDetails)
+
+	return commandInvocationDetails, nil
+}
+
+// isCommandFinished reports whether a command invocation has reached a terminal status.
+func isCommandFinished(status ssmTypes.CommandInvocationStatus) bool {
+	switch status {
+	case ssmTypes.CommandInvocationStatusPending, ssmTypes.CommandInvocationStatusInProgress, ssmTypes.CommandInvocationStatusDelayed, ssmTypes.CommandInvocationStatusCancelling:
+		return false
+	}
+	return true
+}
+
+// printCommandInvocationDetails prints the status and output of a command invocation in a collapsed group.
+func printCommandInvocationDetails(logger Logger, commandInvocationDetails *ssm.GetCommandInvocationOutput) {
+	logger.Group("Command invocation details")
+	logger.Infof("ResponseCode: %d", commandInvocationDetails.ResponseCode)
+	logger.Infof("Status: %s", commandInvocationDetails.Status)
+	logger.Infof("StdError: %s", aws.ToString(commandInvocationDetails.StandardErrorContent))
+	if len(aws.ToString(commandInvocationDetails.StandardOutputContent)) > 1000 {
+		logger.Infof("StdOutput: %s...", (*commandInvocationDetails.StandardOutputContent)[:1000])
+		logger.Infof("(enable debug to see full output)")
+		logger.Debugf("StdOutput: %s", *commandInvocationDetails.StandardOutputContent)
+	} else {
+		logger.Infof("StdOutput: %s", aws.ToString(commandInvocationDetails.StandardOutputContent))
+	}
+	logger.EndGroup()
+}
+
+// GetCommandInvocationDetails retrieves the details of a command invocation from AWS Systems Manager (SSM).
+// It returns the *ssm.GetCommandInvocationOutput object containing the command invocation details, or an error if any.
+// If the command invocation details are not available within the specified maxWaitTime, it returns a timeout error.
+func GetCommandInvocationDetails(ctx context.Context, logger Logger, ssmClient SSMAPI, ec2InstanceId, commandId CommandId, maxWaitTime int) (*ssm.GetCommandInvocationOutput, error) {
+	getCommandParams := &ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandId),
+		InstanceId: aws.String(ec2InstanceId),
+	}
+	waiter := ssm.NewCommandExecutedWaiter(ssmClient)
+	return waiter.WaitForOutput(ctx, getCommandParams, time.Duration(maxWaitTime)*time.Second)
+}
+
+// CancelCommand cancels a command that is still pending or in progress on an EC2 instance.
+func CancelCommand(ctx context.Context, logger Logger, ssmClient SSMAPI, ec2InstanceId string, commandId CommandId) error {
+	cancelParams := &ssm.CancelCommandInput{
+		CommandId:   aws.String(commandId),
+		InstanceIds: []string{ec2InstanceId},
+	}
+
+	if _, err := ssmClient.CancelCommand(ctx, cancelParams); err != nil {
+		return fmt.Errorf("error cancelling command %s on EC2 instance %s: %w", commandId, ec2InstanceId, err)
+	}
+	logger.Infof("Cancelled command %s on instance %s", commandId, ec2InstanceId)
+	return nil
+}
+
+// IsSSMAgentRegistered checks if the SSM agent is registered and online for a given EC2 instance.
+// The function returns true if the SSM agent is registered and online, false otherwise.
+// An error is returned if there was a problem with the SSM client or if the timeout was reached.
+func IsSSMAgentRegistered(ctx context.Context, logger Logger, ssmClient SSMAPI, ec2InstanceId string, timeout, interval int) (bool, error) {
+	endTime := now(ctx).Add(time.Duration(timeout) * time.Second)
+
+	describeInstanceInfoParams := &ssm.DescribeInstanceInformationInput{
+		Filters: []ssmTypes.InstanceInformationStringFilter{
+			{
+				Key:    aws.String("InstanceIds"),
+				Values: []string{ec2InstanceId},
+			},
+		},
+	}
+
+	for now(ctx).Before(endTime) {
+		resp, err := ssmClient.DescribeInstanceInformation(ctx, describeInstanceInfoParams)
+		if err != nil {
+			return false, err
+		}
+
+		if len(resp.InstanceInformationList) > 0 {
+			for _, instanceInfo := range resp.InstanceInformationList {
+				if *instanceInfo.InstanceId == ec2InstanceId && instanceInfo.PingStatus == ssmTypes.PingStatusOnline {
+					logger.Infof("SSM agent is registered and online for instance %s", ec2InstanceId)
+					return true, nil
+				}
+			}
+		}
+		logger.Infof("SSM agent is not registered or not online for instance %s. Waiting...", ec2InstanceId)
+		if err := sleepContext(ctx, time.Duration(interval)*time.Second); err != nil {
+			return false, err
+		}
+	}
+
+	logger.Infof("Timeout reached. SSM agent is not registered for instance %s", ec2InstanceId)
+	return false, nil
+}
+
+// TerminateEC2Instance terminates the specified EC2 instance. It returns the instance's state change, if EC2
+// reported one.
+func TerminateEC2Instance(ctx context.Context, logger Logger, ec2Client EC2API, ec2InstanceId string) (*ec2Types.InstanceStateChange, error) {
+	stopParams := &ec2.TerminateInstancesInput{
+		InstanceIds: []string{ec2InstanceId},
+	}
+
+	resp, err := ec2Client.TerminateInstances(ctx, stopParams)
+	if err != nil {
+		return nil, fmt.Errorf("error stopping EC2 instance %s: %w", ec2InstanceId, err)
+	}
+	logger.Infof("Instance %s is stopping...", ec2InstanceId)
+	if len(resp.TerminatingInstances) == 0 {
+		return nil, nil
+	}
+	return &resp.TerminatingInstances[0], nil
+}
+
+// DescribeEC2Instance returns the details of the specified EC2 instance.
+func DescribeEC2Instance(ctx context.Context, ec2Client EC2API, ec2InstanceId string) (*ec2Types.Instance, error) {
+	resp, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{ec2InstanceId},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing instance %s: %w", ec2InstanceId, err)
+	}
+	if len(resp.Reservations) == 0 || len(resp.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("instance %s not found", ec2InstanceId)
+	}
+	return &resp.Reservations[0].Instances[0], nil
+}
+
+// newClientToken returns a random token identifying a request for idempotent retries.
+func newClientToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// isErrorCode reports whether err is an AWS API error with the given error code.
+func isErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
@@ -0,0 +1,527 @@
+package runner
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+	"github.com/sethvargo/go-githubactions"
+)
+
+const testEC2ClientId = "i-1234567890abcdef0"
+
+// Mock implementations. Each is an account where every call succeeds; tests of failures embed one in a fake that
+// overrides just the calls that fail.
+
+// MockEC2Client launches instances that are running, pass their status checks and terminate, and reports that
+// dry runs would succeed. The AMI, subnet, security group and instance type it describes are compatible.
+type MockEC2Client struct{}
+
+func (m *MockEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return describeInstanceOutput(ec2Types.InstanceStateNameRunning, nil), nil
+}
+
+// describeInstanceOutput returns a DescribeInstances result for the test instance in state.
+func describeInstanceOutput(state ec2Types.InstanceStateName, reason *ec2Types.StateReason) *ec2.DescribeInstancesOutput {
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []ec2Types.Reservation{{
+			Instances: []ec2Types.Instance{{
+				InstanceId:  aws.String(testEC2ClientId),
+				State:       &ec2Types.InstanceState{Name: state},
+				StateReason: reason,
+			}},
+		}},
+	}
+}
+
+func (m *MockEC2Client) DescribeInstanceStatus(ctx context.Context, params *ec2.DescribeInstanceStatusInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error) {
+	return describeInstanceStatusOutput(ec2Types.SummaryStatusOk), nil
+}
+
+// describeInstanceStatusOutput returns a DescribeInstanceStatus result for the test instance with status.
+func describeInstanceStatusOutput(status ec2Types.SummaryStatus) *ec2.DescribeInstanceStatusOutput {
+	return &ec2.DescribeInstanceStatusOutput{
+		InstanceStatuses: []ec2Types.InstanceStatus{{
+			InstanceId:     aws.String(testEC2ClientId),
+			SystemStatus:   &ec2Types.InstanceStatusSummary{Status: ec2Types.SummaryStatusOk},
+			InstanceStatus: &ec2Types.InstanceStatusSummary{Status: status},
+		}},
+	}
+}
+
+func (m *MockEC2Client) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	if aws.ToBool(params.DryRun) {
+		return nil, dryRunSucceeded
+	}
+	return &ec2.RunInstancesOutput{
+		Instances: []ec2Types.Instance{{InstanceId: aws.String(testEC2ClientId)}},
+	}, nil
+}
+
+func (m *MockEC2Client) TerminateInstances(ctx context.Context, params *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	if aws.ToBool(params.DryRun) {
+		return nil, dryRunSucceeded
+	}
+	return &ec2.TerminateInstancesOutput{
+		TerminatingInstances: []ec2Types.InstanceStateChange{{
+			InstanceId:   aws.String(testEC2ClientId),
+			CurrentState: &ec2Types.InstanceState{Name: ec2Types.InstanceStateNameTerminated},
+		}},
+	}, nil
+}
+
+// dryRunSucceeded is the error EC2 returns for a dry run request that would have succeeded.
+var dryRunSucceeded = &smithy.GenericAPIError{Code: "DryRunOperation", Message: "Request would have succeeded, but DryRun flag is set."}
+
+// GetConsoleOutput returns no output, as for an instance that has only just started.
+func (m *MockEC2Client) GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error) {
+	return &ec2.GetConsoleOutputOutput{InstanceId: aws.String(testEC2ClientId)}, nil
+}
+
+func (m *MockEC2Client) GetConsoleScreenshot(ctx context.Context, params *ec2.GetConsoleScreenshotInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleScreenshotOutput, error) {
+	return &ec2.GetConsoleScreenshotOutput{
+		InstanceId: aws.String(testEC2ClientId),
+		ImageData:  aws.String(base64.StdEncoding.EncodeToString([]byte("jpeg"))),
+	}, nil
+}
+
+func (m *MockEC2Client) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{
+		Images: []ec2Types.Image{{ImageId: aws.String(params.ImageIds[0]), State: ec2Types.ImageStateAvailable, Architecture: ec2Types.ArchitectureValuesX8664}},
+	}, nil
+}
+
+func (m *MockEC2Client) DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	return &ec2.DescribeSubnetsOutput{
+		Subnets: []ec2Types.Subnet{{SubnetId: aws.String(params.SubnetIds[0]), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String("us-east-1a")}},
+	}, nil
+}
+
+func (m *MockEC2Client) DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	var groups []ec2Types.SecurityGroup
+	for _, id := range params.GroupIds {
+		groups = append(groups, ec2Types.SecurityGroup{GroupId: aws.String(id), VpcId: aws.String("vpc-1")})
+	}
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: groups}, nil
+}
+
+func (m *MockEC2Client) DescribeInstanceTypeOfferings(ctx context.Context, params *ec2.DescribeInstanceTypeOfferingsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+	return &ec2.DescribeInstanceTypeOfferingsOutput{
+		InstanceTypeOfferings: []ec2Types.InstanceTypeOffering{{InstanceType: ec2Types.InstanceType(params.Filters[0].Values[0])}},
+	}, nil
+}
+
+func (m *MockEC2Client) DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+	return &ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: []ec2Types.InstanceTypeInfo{{
+			InstanceType:  params.InstanceTypes[0],
+			ProcessorInfo: &ec2Types.ProcessorInfo{SupportedArchitectures: []ec2Types.ArchitectureType{ec2Types.ArchitectureTypeI386, ec2Types.ArchitectureTypeX8664}},
+		}},
+	}, nil
+}
+
+// MockSSMClient runs every command sent successfully, printing "Hello World!", and records the commands.
+type MockSSMClient struct {
+	commands []string
+}
+
+func (m *MockSSMClient) DescribeInstanceInformation(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error) {
+	return &ssm.DescribeInstanceInformationOutput{
+		InstanceInformationList: []ssmTypes.InstanceInformation{{
+			InstanceId: aws.String(testEC2ClientId),
+			PingStatus: ssmTypes.PingStatusOnline,
+		}},
+	}, nil
+}
+
+func (m *MockSSMClient) SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	m.commands = append(m.commands, params.Parameters["commands"]...)
+	return &ssm.SendCommandOutput{
+		Command: &ssmTypes.Command{CommandId: aws.String("command-id-123")},
+	}, nil
+}
+
+func (m *MockSSMClient) GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	return commandInvocationOutput(ssmTypes.CommandInvocationStatusSuccess, 0, "Hello World!", ""), nil
+}
+
+// commandInvocationOutput returns a GetCommandInvocation result for a command on the test instance.
+func commandInvocationOutput(status ssmTypes.CommandInvocationStatus, responseCode int32, stdout, stderr string) *ssm.GetCommandInvocationOutput {
+	return &ssm.GetCommandInvocationOutput{
+		CommandId:             aws.String("command-id-123"),
+		InstanceId:            aws.String(testEC2ClientId),
+		Status:                status,
+		ResponseCode:          responseCode,
+		StandardOutputContent: aws.String(stdout),
+		StandardErrorContent:  aws.String(stderr),
+	}
+}
+
+func (m *MockSSMClient) CancelCommand(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+	return &ssm.CancelCommandOutput{}, nil
+}
+
+// MockIAMClient is an account where the role "test-role" has an instance profile, which is listed on a second page
+// to exercise pagination, and the roles in roles exist. It records the profiles, roles and policies created.
+type MockIAMClient struct {
+	created []string
+	// roles are the names of existing roles, and createdRoles the roles created.
+	roles        []string
+	createdRoles []*iam.CreateRoleInput
+	// attachedPolicies are the policy ARNs attached and inlinePolicies the inline policy documents added.
+	attachedPolicies []string
+	inlinePolicies   []string
+}
+
+func (m *MockIAMClient) ListInstanceProfilesForRole(ctx context.Context, params *iam.ListInstanceProfilesForRoleInput, optFns ...func(*iam.Options)) (*iam.ListInstanceProfilesForRoleOutput, error) {
+	if *params.RoleName != "test-role" {
+		return &iam.ListInstanceProfilesForRoleOutput{}, nil
+	}
+	if params.Marker == nil {
+		return &iam.ListInstanceProfilesForRoleOutput{IsTruncated: true, Marker: aws.String("page-2")}, nil
+	}
+	return &iam.ListInstanceProfilesForRoleOutput{
+		InstanceProfiles: []iamTypes.InstanceProfile{{
+			InstanceProfileName: aws.String("test-role"),
+			Roles:               []iamTypes.Role{{RoleName: aws.String("test-role")}},
+		}},
+	}, nil
+}
+
+func (m *MockIAMClient) GetInstanceProfile(ctx context.Context, params *iam.GetInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.GetInstanceProfileOutput, error) {
+	return &iam.GetInstanceProfileOutput{
+		InstanceProfile: &iamTypes.InstanceProfile{InstanceProfileName: params.InstanceProfileName},
+	}, nil
+}
+
+func (m *MockIAMClient) CreateInstanceProfile(ctx context.Context, params *iam.CreateInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.CreateInstanceProfileOutput, error) {
+	m.created = append(m.created, *params.InstanceProfileName)
+	return &iam.CreateInstanceProfileOutput{}, nil
+}
+
+func (m *MockIAMClient) AddRoleToInstanceProfile(ctx context.Context, params *iam.AddRoleToInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.AddRoleToInstanceProfileOutput, error) {
+	return &iam.AddRoleToInstanceProfileOutput{}, nil
+}
+
+func (m *MockIAMClient) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	for _, role := range m.roles {
+		if role == *params.RoleName {
+			return &iam.GetRoleOutput{Role: &iamTypes.Role{RoleName: params.RoleName}}, nil
+		}
+	}
+	return nil, &smithy.GenericAPIError{Code: "NoSuchEntity", Message: "The role cannot be found."}
+}
+
+func (m *MockIAMClient) CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	m.roles = append(m.roles, *params.RoleName)
+	m.createdRoles = append(m.createdRoles, params)
+	return &iam.CreateRoleOutput{
+		Role: &iamTypes.Role{
+			RoleName: params.RoleName,
+			Arn:      aws.String("arn:aws:iam::123456789012:role/" + *params.RoleName),
+		},
+	}, nil
+}
+
+func (m *MockIAMClient) AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+	m.attachedPolicies = append(m.attachedPolicies, *params.PolicyArn)
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+func (m *MockIAMClient) PutRolePolicy(ctx context.Context, params *iam.PutRolePolicyInput, optFns ...func(*iam.Options)) (*iam.PutRolePolicyOutput, error) {
+	m.inlinePolicies = append(m.inlinePolicies, *params.PolicyDocument)
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+// SimulatePrincipalPolicy allows every action.
+func (m *MockIAMClient) SimulatePrincipalPolicy(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+	var results []iamTypes.EvaluationResult
+	for _, actionName := range params.ActionNames {
+		results = append(results, iamTypes.EvaluationResult{EvalActionName: aws.String(actionName), EvalDecision: iamTypes.PolicyEvaluationDecisionTypeAllowed})
+	}
+	return &iam.SimulatePrincipalPolicyOutput{EvaluationResults: results}, nil
+}
+
+// InstanceStatesEC2Client reports the instance in each of states in turn, repeating the last one, after failing
+// with InvalidInstanceID.NotFound notFound times, as EC2 does until a new instance is visible.
+type InstanceStatesEC2Client struct {
+	MockEC2Client
+	notFound int
+	states   []ec2Types.InstanceStateName
+	reason   *ec2Types.StateReason
+}
+
+func (m *InstanceStatesEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	if m.notFound > 0 {
+		m.notFound--
+		return nil, &smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound", Message: "The instance ID does not exist"}
+	}
+	state := m.states[0]
+	if len(m.states) > 1 {
+		m.states = m.states[1:]
+	}
+	return describeInstanceOutput(state, m.reason), nil
+}
+
+// CommandResultSSMClient runs every command sent with result.
+type CommandResultSSMClient struct {
+	MockSSMClient
+	result *ssm.GetCommandInvocationOutput
+}
+
+func (m *CommandResultSSMClient) GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	return m.result, nil
+}
+
+// Unit tests
+
+func TestWaitForInstanceRunning(t *testing.T) {
+	pending, running, terminated := ec2Types.InstanceStateNamePending, ec2Types.InstanceStateNameRunning, ec2Types.InstanceStateNameTerminated
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		client  *InstanceStatesEC2Client
+		timeout int
+		// err is a substring of the expected error, or empty if none is expected.
+		err string
+	}{
+		{"running", context.Background(), &InstanceStatesEC2Client{states: []ec2Types.InstanceStateName{running}}, 60, ""},
+		{"not found then pending", context.Background(), &InstanceStatesEC2Client{notFound: 1, states: []ec2Types.InstanceStateName{pending, running}}, 60, ""},
+		{"terminated", context.Background(), &InstanceStatesEC2Client{
+			states: []ec2Types.InstanceStateName{pending, terminated},
+			reason: &ec2Types.StateReason{Code: aws.String("Client.InvalidKMSKey.InvalidState"), Message: aws.String("The KMS key provided is in an incorrect state")},
+		}, 60, "terminated: Client.InvalidKMSKey.InvalidState"},
+		{"timeout", context.Background(), &InstanceStatesEC2Client{states: []ec2Types.InstanceStateName{pending}}, 0, "timed out"},
+		{"cancelled", cancelled, &InstanceStatesEC2Client{states: []ec2Types.InstanceStateName{pending}}, 600, context.Canceled.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WaitForInstanceRunning(tt.ctx, githubactions.New(), tt.client, testEC2ClientId, tt.timeout, 0)
+			if tt.err == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// ExistingProfileIAMClient is an account where an instance profile named for the role already exists, holding
+// role, but isn't listed for the role, as when it was left behind by an earlier run.
+type ExistingProfileIAMClient struct {
+	MockIAMClient
+	role string
+}
+
+func (m *ExistingProfileIAMClient) CreateInstanceProfile(ctx context.Context, params *iam.CreateInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.CreateInstanceProfileOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "EntityAlreadyExists", Message: "Instance Profile already exists."}
+}
+
+func (m *ExistingProfileIAMClient) AddRoleToInstanceProfile(ctx context.Context, params *iam.AddRoleToInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.AddRoleToInstanceProfileOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "LimitExceeded", Message: "Cannot exceed quota for InstanceSessionsPerInstanceProfile: 1"}
+}
+
+func (m *ExistingProfileIAMClient) GetInstanceProfile(ctx context.Context, params *iam.GetInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.GetInstanceProfileOutput, error) {
+	return &iam.GetInstanceProfileOutput{
+		InstanceProfile: &iamTypes.InstanceProfile{
+			InstanceProfileName: params.InstanceProfileName,
+			Roles:               []iamTypes.Role{{RoleName: aws.String(m.role)}},
+		},
+	}, nil
+}
+
+func TestGetOrCreateInstanceProfile(t *testing.T) {
+	tests := []struct {
+		name   string
+		client IAMAPI
+		role   string
+		// created are the instance profiles expected to be created.
+		created []string
+		err     bool
+	}{
+		{"listed for the role", &MockIAMClient{}, "test-role", nil, false},
+		{"created", &MockIAMClient{}, "new-role", []string{"new-role"}, false},
+		{"left behind with the role", &ExistingProfileIAMClient{role: "new-role"}, "new-role", nil, false},
+		{"left behind with another role", &ExistingProfileIAMClient{role: "other-role"}, "new-role", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profileName, err := GetOrCreateInstanceProfile(context.Background(), githubactions.New(), tt.client, tt.role)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got instance profile %s", profileName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if profileName != tt.role {
+				t.Fatalf("expected instance profile %s, got %s", tt.role, profileName)
+			}
+			if mock, ok := tt.client.(*MockIAMClient); ok && strings.Join(mock.created, ",") != strings.Join(tt.created, ",") {
+				t.Fatalf("expected instance profiles %v to be created, got %v", tt.created, mock.created)
+			}
+		})
+	}
+}
+
+// UnpropagatedProfileEC2Client rejects the instance profile of the first rejections launches, as EC2 does until a
+// new instance profile has propagated.
+type UnpropagatedProfileEC2Client struct {
+	MockEC2Client
+	rejections int
+}
+
+func (m *UnpropagatedProfileEC2Client) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	if m.rejections > 0 {
+		m.rejections--
+		return nil, &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "Value (test-role) for parameter iamInstanceProfile.name is invalid. Invalid IAM Instance Profile name"}
+	}
+	return m.MockEC2Client.RunInstances(ctx, params, optFns...)
+}
+
+func TestRunInstancesWithProfileRetry(t *testing.T) {
+	params := &ec2.RunInstancesInput{
+		IamInstanceProfile: &ec2Types.IamInstanceProfileSpecification{Name: aws.String("test-role")},
+	}
+	tests := []struct {
+		name       string
+		rejections int
+		timeout    int
+		err        bool
+	}{
+		{"propagated while retrying", 2, 60, false},
+		{"not propagated in time", 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &UnpropagatedProfileEC2Client{rejections: tt.rejections}
+			_, err := RunInstancesWithProfileRetry(context.Background(), githubactions.New(), client, params, tt.timeout, 0)
+			if tt.err != isInstanceProfilePropagationError(err) || (!tt.err && err != nil) {
+				t.Fatalf("expected instance profile error %t, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestIsSSMAgentRegistered(t *testing.T) {
+	tests := []struct {
+		instanceId string
+		timeout    int
+		want       bool
+	}{
+		{testEC2ClientId, 60, true},
+		{testEC2ClientId + "xx", 0, false},
+	}
+	for _, tt := range tests {
+		registered, err := IsSSMAgentRegistered(context.Background(), githubactions.New(), &MockSSMClient{}, tt.instanceId, tt.timeout, 0)
+		if err != nil || registered != tt.want {
+			t.Fatalf("expected %s registered %t, got %t, %v", tt.instanceId, tt.want, registered, err)
+		}
+	}
+}
+
+func TestExecuteCommandOnEC2Instance(t *testing.T) {
+	mockSSM := &MockSSMClient{}
+
+	commandId, details, err := ExecuteCommandOnEC2Instance(context.Background(), githubactions.New(), mockSSM, testEC2ClientId, "echo 'Hello, World!'", 60, 60)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if commandId != "command-id-123" || details.Status != ssmTypes.CommandInvocationStatusSuccess || *details.StandardOutputContent != "Hello World!" {
+		t.Fatalf("expected the successful invocation, got %s, %+v", commandId, details)
+	}
+	if len(mockSSM.commands) != 1 || mockSSM.commands[0] != "echo 'Hello, World!'" {
+		t.Fatalf("expected the command to be sent, got %v", mockSSM.commands)
+	}
+}
+
+func TestSendCommandToEC2Instance(t *testing.T) {
+	commandId, err := SendCommandToEC2Instance(context.Background(), githubactions.New(), &MockSSMClient{}, testEC2ClientId, "sleep 600", 60)
+	if err != nil || commandId != "command-id-123" {
+		t.Fatalf("expected command ID command-id-123, got %s, %v", commandId, err)
+	}
+
+	// The SSM agent of another instance never comes online, so the command isn't sent.
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}
+	start := clock.now
+	mockSSM := &MockSSMClient{}
+	_, err = SendCommandToEC2Instance(withClock(context.Background(), clock), githubactions.New(), mockSSM, "i-other", "sleep 600", 30)
+	if err == nil || !strings.Contains(err.Error(), "after 30 seconds") || len(mockSSM.commands) != 0 {
+		t.Fatalf("expected the SSM agent not to be online after 30 seconds, got %v, %v", err, mockSSM.commands)
+	}
+	if waited := clock.now.Sub(start); waited != 30*time.Second {
+		t.Fatalf("expected to wait 30 seconds for the SSM agent, waited %s", waited)
+	}
+}
+
+func TestWaitForCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		client SSMAPI
+		status ssmTypes.CommandInvocationStatus
+		// err is a substring of the expected error, or empty if none is expected.
+		err string
+	}{
+		{"success", &MockSSMClient{}, ssmTypes.CommandInvocationStatusSuccess, ""},
+		{"failed", &CommandResultSSMClient{result: commandInvocationOutput(ssmTypes.CommandInvocationStatusFailed, 1, "", "something went wrong")}, ssmTypes.CommandInvocationStatusFailed, "something went wrong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := WaitForCommand(context.Background(), githubactions.New(), tt.client, testEC2ClientId, "command-id-123", 60)
+			if tt.err == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+			if details == nil || details.Status != tt.status {
+				t.Fatalf("expected invocation details with status %s, got %+v", tt.status, details)
+			}
+		})
+	}
+}
+
+func TestCancelCommand(t *testing.T) {
+	if err := CancelCommand(context.Background(), githubactions.New(), &MockSSMClient{}, testEC2ClientId, "command-id-123"); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+}
+
+func TestTerminateEC2Instance(t *testing.T) {
+	change, err := TerminateEC2Instance(context.Background(), githubactions.New(), &MockEC2Client{}, testEC2ClientId)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if change == nil || change.CurrentState == nil || change.CurrentState.Name != ec2Types.InstanceStateNameTerminated {
+		t.Fatalf("expected the instance to be terminated, got %+v", change)
+	}
+}
+
+func TestParseTagSpecifications(t *testing.T) {
+	tags, err := ParseTagSpecifications(`[{"ResourceType":"instance","Tags":[{"Key":"Purpose","Value":"ci"}]}]`)
+	if err != nil || len(tags) != 1 || tags[0].ResourceType != ec2Types.ResourceTypeInstance {
+		t.Fatalf("expected an instance tag specification, got %v, %v", tags, err)
+	}
+	if tags, err := ParseTagSpecifications(""); err != nil || tags != nil {
+		t.Fatalf("expected no tag specifications, got %v, %v", tags, err)
+	}
+	if _, err := ParseTagSpecifications("{"); err == nil {
+		t.Fatalf("expected an error for invalid tag specifications")
+	}
+}
@@ -0,0 +1,433 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Exit codes of the command line. A failed command exits with the exit code of the remote command instead, if it
+// has one.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// cliEnvPrefix prefixes the environment variables that flags fall back to, e.g. EC2_RUNNER_INSTANCE_ID for
+// --instance-id.
+const cliEnvPrefix = "EC2_RUNNER_"
+
+const cliUsage = `Usage: ec2-runner <command> [flags] [args]
+
+Commands:
+  start     Launch an instance and wait for it to be ready, printing its ID
+  command   Run a shell command on an instance with SSM, e.g. ec2-runner command --instance-id i-123 -- uname -a
+  stop      Terminate an instance
+  status    Print the state of an instance
+
+Every flag can also be set with an EC2_RUNNER_<FLAG> environment variable, e.g. EC2_RUNNER_INSTANCE_ID.
+Run ec2-runner <command> -h for the flags of a command.
+`
+
+// connectFunc returns the AWS clients used by the command line for a region and shared config profile, either of
+// which may be empty to use the default. The clients' calls are retried by the RunnerManager.
+type connectFunc func(ctx context.Context, region, profile string) (EC2API, SSMAPI, IAMAPI, error)
+
+// cliCommand is a parsed invocation of a command line subcommand.
+type cliCommand struct {
+	logger  Logger
+	stdout  io.Writer
+	json    bool
+	manager *RunnerManager
+}
+
+// cliRunFunc runs a parsed subcommand, returning its result, its exit code and the error it failed with, if any.
+type cliRunFunc func(ctx context.Context, c *cliCommand) (any, int, error)
+
+// RunCLI runs the ec2-runner command line with args, the arguments after the program name, and returns the exit
+// code. Results are written to stdout, as text or as JSON with --output json, and progress and errors to stderr.
+func RunCLI(ctx context.Context, args []string, stdout, stderr io.Writer, getenv func(string) string, connect connectFunc) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, cliUsage)
+		return exitUsage
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Fprint(stdout, cliUsage)
+		return exitOK
+	}
+
+	fs := flag.NewFlagSet("ec2-runner "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	region := fs.String("region", "", "AWS region (default from the AWS environment)")
+	profile := fs.String("profile", "", "AWS shared config profile (default from the AWS environment)")
+	output := fs.String("output", "text", "Output format: text or json")
+	logFormat := fs.String("log-format", "", "Progress log format: github, gitlab, json or text (default detected from the CI system)")
+	debug := fs.Bool("debug", false, "Log debug messages")
+
+	var run cliRunFunc
+	var usageErr error
+	switch name {
+	case "start":
+		run, usageErr = startCommand(fs, args[1:], getenv)
+	case "command":
+		run, usageErr = commandCommand(fs, args[1:], getenv)
+	case "stop":
+		run, usageErr = stopCommand(fs, args[1:], getenv)
+	case "status":
+		run, usageErr = statusCommand(fs, args[1:], getenv)
+	default:
+		fmt.Fprintf(stderr, "ec2-runner: unknown command %q\n\n%s", name, cliUsage)
+		return exitUsage
+	}
+	if errors.Is(usageErr, flag.ErrHelp) {
+		return exitOK
+	}
+	if usageErr == nil && *output != "text" && *output != "json" {
+		usageErr = fmt.Errorf("invalid output format %q, expected text or json", *output)
+	}
+	if usageErr != nil {
+		fmt.Fprintf(stderr, "ec2-runner %s: %v\n", name, usageErr)
+		return exitUsage
+	}
+
+	if *logFormat == "" {
+		*logFormat = DetectLogFormat(getenv)
+	}
+	// Progress is logged to stderr, so that stdout only has the result.
+	logger, err := NewLogger(*logFormat, stderr, *debug)
+	if err != nil {
+		fmt.Fprintf(stderr, "ec2-runner %s: %v\n", name, err)
+		return exitUsage
+	}
+	c := &cliCommand{logger: logger, stdout: stdout, json: *output == "json"}
+	ec2Client, ssmClient, iamClient, err := connect(ctx, *region, *profile)
+	if err == nil {
+		c.manager, err = NewRunnerManager(WithEC2Client(ec2Client), WithSSMClient(ssmClient), WithIAMClient(iamClient), WithLogger(c.logger))
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "ec2-runner %s: %v\n", name, err)
+		return exitError
+	}
+
+	result, code, err := run(ctx, c)
+	if err != nil {
+		fmt.Fprintf(stderr, "ec2-runner %s: %v\n", name, err)
+	}
+	if c.json && result != nil {
+		if err := json.NewEncoder(stdout).Encode(result); err != nil {
+			fmt.Fprintf(stderr, "ec2-runner %s: %v\n", name, err)
+			return exitError
+		}
+	}
+	return code
+}
+
+// parseFlags parses args with fs, then sets each flag that wasn't given from its EC2_RUNNER_<FLAG> environment
+// variable, if that is set.
+func parseFlags(fs *flag.FlagSet, args []string, getenv func(string) string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := cliEnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v := getenv(env); v != "" && !given[f.Name] && err == nil {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, env, serr)
+			}
+		}
+	})
+	return err
+}
+
+// requireFlags returns an error naming the flags that are empty, if any.
+func requireFlags(flags map[string]string) error {
+	var missing []string
+	for name, value := range flags {
+		if value == "" {
+			missing = append(missing, "--"+name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("missing required flags: %s", strings.Join(missing, ", "))
+}
+
+// instanceIdArg returns the instance ID given as the only argument, or with the instance-id flag.
+func instanceIdArg(fs *flag.FlagSet, flagValue string) (string, error) {
+	switch {
+	case fs.NArg() > 1:
+		return "", fmt.Errorf("expected one instance ID, got %s", strings.Join(fs.Args(), " "))
+	case fs.NArg() == 1:
+		return fs.Arg(0), nil
+	case flagValue == "":
+		return "", fmt.Errorf("missing instance ID, give it as an argument or with --instance-id")
+	}
+	return flagValue, nil
+}
+
+// cliStartResult is the result of the start command.
+type cliStartResult struct {
+	InstanceId string `json:"ec2-instance-id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func startCommand(fs *flag.FlagSet, args []string, getenv func(string) string) (cliRunFunc, error) {
+	imageId := fs.String("image-id", "", "AMI ID of the instance (required)")
+	subnetId := fs.String("subnet-id", "", "Subnet ID of the instance (required)")
+	securityGroupId := fs.String("security-group-id", "", "Security group ID of the instance (required)")
+	instanceType := fs.String("instance-type", defaultInstanceType, "Instance type")
+	iamRoleName := fs.String("iam-role-name", "", "IAM role of the instance")
+	userData := fs.String("user-data", "", "User data script of the instance")
+	tagSpecifications := fs.String("tag-specifications", "", "JSON array of EC2 tag specifications")
+	waitFor := fs.String("wait-for", "", "Comma separated readiness conditions to wait for (default running)")
+	maxWaitSecs := fs.Int("max-wait-secs", 300, "Seconds to wait for the instance to be running")
+	skipPreflight := fs.Bool("skip-preflight", false, "Skip checking the AMI, subnet, security group and instance type")
+	if err := parseFlags(fs, args, getenv); err != nil {
+		return nil, err
+	}
+	if err := requireFlags(map[string]string{"image-id": *imageId, "subnet-id": *subnetId, "security-group-id": *securityGroupId}); err != nil {
+		return nil, err
+	}
+	readiness, err := ParseReadinessConditions([]string{*waitFor}, *maxWaitSecs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --wait-for: %v", err)
+	}
+	tags, err := ParseTagSpecifications(*tagSpecifications)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --tag-specifications: %v", err)
+	}
+	spec := LaunchSpec{
+		ImageId:           *imageId,
+		SubnetId:          *subnetId,
+		SecurityGroupId:   *securityGroupId,
+		InstanceType:      *instanceType,
+		IAMRoleName:       *iamRoleName,
+		UserData:          *userData,
+		TagSpecifications: tags,
+		Readiness:         readiness,
+		SkipPreflight:     *skipPreflight,
+	}
+
+	return func(ctx context.Context, c *cliCommand) (any, int, error) {
+		result, err := c.manager.Start(ctx, spec)
+		if err != nil {
+			var instanceId string
+			if result != nil {
+				instanceId = result.InstanceId
+				c.logger.Warningf("Instance %s was launched but is not ready; it has been left running.", instanceId)
+			}
+			return cliStartResult{InstanceId: instanceId, Error: err.Error()}, exitError, err
+		}
+		if !c.json {
+			fmt.Fprintln(c.stdout, result.InstanceId)
+		}
+		return cliStartResult{InstanceId: result.InstanceId}, exitOK, nil
+	}, nil
+}
+
+// cliCommandResult is the result of the command command.
+type cliCommandResult struct {
+	InstanceId string `json:"ec2-instance-id"`
+	CommandId  string `json:"command-id,omitempty"`
+	Status     string `json:"status,omitempty"`
+	ExitCode   *int32 `json:"exit-code,omitempty"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func commandCommand(fs *flag.FlagSet, args []string, getenv func(string) string) (cliRunFunc, error) {
+	instanceId := fs.String("instance-id", "", "ID of the instance (required)")
+	maxWaitSecs := fs.Int("max-wait-secs", 300, "Seconds to wait for the command to complete")
+	ssmAgentMaxWaitSecs := fs.Int("ssm-agent-max-wait-secs", defaultSSMAgentMaxWaitTime, "Seconds to wait for the instance's SSM agent to be online")
+	async := fs.Bool("async", false, "Print the command ID without waiting for the command to complete")
+	if err := parseFlags(fs, args, getenv); err != nil {
+		return nil, err
+	}
+	command := strings.Join(fs.Args(), " ")
+	if err := requireFlags(map[string]string{"instance-id": *instanceId}); err != nil {
+		return nil, err
+	}
+	if command == "" {
+		return nil, fmt.Errorf("missing command to run")
+	}
+
+	spec := CommandSpec{
+		InstanceId:      *instanceId,
+		Command:         command,
+		Timeout:         time.Duration(*maxWaitSecs) * time.Second,
+		SSMAgentTimeout: time.Duration(*ssmAgentMaxWaitSecs) * time.Second,
+		NoWait:          *async,
+	}
+
+	return func(ctx context.Context, c *cliCommand) (any, int, error) {
+		result := cliCommandResult{InstanceId: *instanceId}
+		invocation, err := c.manager.RunCommand(ctx, spec)
+		code := exitOK
+		if err != nil {
+			result.Error = err.Error()
+			code = exitError
+		}
+		if invocation == nil {
+			return result, code, err
+		}
+		result.CommandId = invocation.CommandId
+		if *async {
+			if !c.json {
+				fmt.Fprintln(c.stdout, invocation.CommandId)
+			}
+			return result, code, err
+		}
+		if invocation.Status != "" {
+			result.Status = string(invocation.Status)
+			result.ExitCode = aws.Int32(invocation.ExitCode)
+			result.Stdout = invocation.Stdout
+			result.Stderr = invocation.Stderr
+			// Exit with the remote command's exit code, like ssh.
+			if err != nil && invocation.ExitCode > 0 {
+				code = int(min(invocation.ExitCode, 255))
+			}
+			if !c.json {
+				fmt.Fprint(c.stdout, result.Stdout)
+			}
+		}
+		return result, code, err
+	}, nil
+}
+
+// cliStopResult is the result of the stop command.
+type cliStopResult struct {
+	InstanceId string `json:"ec2-instance-id"`
+	Error      string `json:"error,omitempty"`
+}
+
+func stopCommand(fs *flag.FlagSet, args []string, getenv func(string) string) (cliRunFunc, error) {
+	instanceIdFlag := fs.String("instance-id", "", "ID of the instance, instead of the argument")
+	if err := parseFlags(fs, args, getenv); err != nil {
+		return nil, err
+	}
+	instanceId, err := instanceIdArg(fs, *instanceIdFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, c *cliCommand) (any, int, error) {
+		if _, err := c.manager.Stop(ctx, instanceId); err != nil {
+			return cliStopResult{InstanceId: instanceId, Error: err.Error()}, exitError, err
+		}
+		return cliStopResult{InstanceId: instanceId}, exitOK, nil
+	}, nil
+}
+
+// cliStatusResult is the result of the status command.
+type cliStatusResult struct {
+	InstanceId       string `json:"ec2-instance-id"`
+	State            string `json:"state,omitempty"`
+	StateReason      string `json:"state-reason,omitempty"`
+	InstanceType     string `json:"instance-type,omitempty"`
+	ImageId          string `json:"image-id,omitempty"`
+	AvailabilityZone string `json:"availability-zone,omitempty"`
+	LaunchTime       string `json:"launch-time,omitempty"`
+	PrivateIpAddress string `json:"private-ip-address,omitempty"`
+	PublicIpAddress  string `json:"public-ip-address,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+func statusCommand(fs *flag.FlagSet, args []string, getenv func(string) string) (cliRunFunc, error) {
+	instanceIdFlag := fs.String("instance-id", "", "ID of the instance, instead of the argument")
+	if err := parseFlags(fs, args, getenv); err != nil {
+		return nil, err
+	}
+	instanceId, err := instanceIdArg(fs, *instanceIdFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, c *cliCommand) (any, int, error) {
+		instance, err := c.manager.Describe(ctx, instanceId)
+		if err != nil {
+			return cliStatusResult{InstanceId: instanceId, Error: err.Error()}, exitError, err
+		}
+		result := cliStatusResult{
+			InstanceId:       instanceId,
+			InstanceType:     string(instance.InstanceType),
+			ImageId:          aws.ToString(instance.ImageId),
+			PrivateIpAddress: aws.ToString(instance.PrivateIpAddress),
+			PublicIpAddress:  aws.ToString(instance.PublicIpAddress),
+		}
+		if instance.State != nil {
+			result.State = string(instance.State.Name)
+		}
+		if instance.StateReason != nil {
+			result.StateReason = aws.ToString(instance.StateReason.Message)
+		}
+		if instance.Placement != nil {
+			result.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+		}
+		if instance.LaunchTime != nil {
+			result.LaunchTime = instance.LaunchTime.UTC().Format("2006-01-02T15:04:05Z")
+		}
+		if !c.json {
+			for _, field := range [][2]string{
+				{"Instance", result.InstanceId},
+				{"State", result.State},
+				{"State reason", result.StateReason},
+				{"Instance type", result.InstanceType},
+				{"AMI", result.ImageId},
+				{"Availability zone", result.AvailabilityZone},
+				{"Launch time", result.LaunchTime},
+				{"Private IP", result.PrivateIpAddress},
+				{"Public IP", result.PublicIpAddress},
+			} {
+				if field[1] != "" {
+					fmt.Fprintf(c.stdout, "%-18s %s\n", field[0]+":", field[1])
+				}
+			}
+		}
+		return result, exitOK, nil
+	}, nil
+}
+
+// ConnectAWS returns the AWS clients used by the command line, configured from the environment like the AWS CLI.
+// The SDK's retryer is disabled, since calls are retried by the RunnerManager.
+func ConnectAWS(ctx context.Context, region, profile string) (EC2API, SSMAPI, IAMAPI, error) {
+	var configOptions []func(*config.LoadOptions) error
+	if region != "" {
+		configOptions = append(configOptions, config.WithRegion(region))
+	}
+	if profile != "" {
+		configOptions = append(configOptions, config.WithSharedConfigProfile(profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, configOptions...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if cfg.Region == "" {
+		return nil, nil, nil, fmt.Errorf("no AWS region, set --region, EC2_RUNNER_REGION or AWS_REGION")
+	}
+
+	ec2Client := ec2.NewFromConfig(cfg, func(o *ec2.Options) { o.Retryer = aws.NopRetryer{} })
+	ssmClient := ssm.NewFromConfig(cfg, func(o *ssm.Options) { o.Retryer = aws.NopRetryer{} })
+	iamClient := iam.NewFromConfig(cfg, func(o *iam.Options) { o.Retryer = aws.NopRetryer{} })
+	return ec2Client, ssmClient, iamClient, nil
+}
@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// runTestCLI runs the command line with mock clients, returning its exit code, stdout and stderr.
+func runTestCLI(t *testing.T, ec2Client EC2API, ssmClient SSMAPI, env map[string]string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	connect := func(ctx context.Context, region, profile string) (EC2API, SSMAPI, IAMAPI, error) {
+		return ec2Client, ssmClient, &MockIAMClient{}, nil
+	}
+	code := RunCLI(context.Background(), args, &stdout, &stderr, func(key string) string { return env[key] }, connect)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLIStart(t *testing.T) {
+	code, stdout, stderr := runTestCLI(t, &MockEC2Client{}, &MockSSMClient{}, nil, "start", "--image-id", "ami-1", "--subnet-id", "subnet-1", "--security-group-id", "sg-1")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	if stdout != testEC2ClientId+"\n" {
+		t.Fatalf("expected the instance ID, got %q", stdout)
+	}
+}
+
+func TestCLIStartMissingFlags(t *testing.T) {
+	code, _, stderr := runTestCLI(t, &MockEC2Client{}, &MockSSMClient{}, map[string]string{"EC2_RUNNER_IMAGE_ID": "ami-1"}, "start")
+	if code != exitUsage {
+		t.Fatalf("expected exit code %d, got %d", exitUsage, code)
+	}
+	if !strings.Contains(stderr, "missing required flags: --security-group-id, --subnet-id") {
+		t.Fatalf("expected the missing flags, got %q", stderr)
+	}
+}
+
+func TestCLICommand(t *testing.T) {
+	ssmClient := &CommandResultSSMClient{result: commandInvocationOutput(ssmTypes.CommandInvocationStatusSuccess, 0, "Linux\n", "")}
+	code, stdout, stderr := runTestCLI(t, &MockEC2Client{}, ssmClient, map[string]string{"EC2_RUNNER_INSTANCE_ID": testEC2ClientId}, "command", "--", "uname", "-s")
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	if stdout != "Linux\n" {
+		t.Fatalf("expected the command's output, got %q", stdout)
+	}
+	if len(ssmClient.commands) != 1 || ssmClient.commands[0] != "uname -s" {
+		t.Fatalf("expected the command to be sent, got %v", ssmClient.commands)
+	}
+}
+
+func TestCLICommandFailedJSON(t *testing.T) {
+	ssmClient := &CommandResultSSMClient{result: commandInvocationOutput(ssmTypes.CommandInvocationStatusFailed, 3, "", "boom")}
+	code, stdout, _ := runTestCLI(t, &MockEC2Client{}, ssmClient, nil, "command", "--instance-id", testEC2ClientId, "--output", "json", "false")
+	if code != 3 {
+		t.Fatalf("expected the command's exit code 3, got %d", code)
+	}
+	var result cliCommandResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("expected a JSON result, got %q: %v", stdout, err)
+	}
+	if result.Status != "Failed" || result.ExitCode == nil || *result.ExitCode != 3 || result.Stderr != "boom" || result.Error == "" {
+		t.Fatalf("expected the failed invocation, got %+v", result)
+	}
+}
+
+func TestCLIStatus(t *testing.T) {
+	code, stdout, stderr := runTestCLI(t, &MockEC2Client{}, &MockSSMClient{}, nil, "status", "--output", "json", testEC2ClientId)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	var result cliStatusResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("expected a JSON result, got %q: %v", stdout, err)
+	}
+	if result.InstanceId != testEC2ClientId || result.State != "running" {
+		t.Fatalf("expected a running instance, got %+v", result)
+	}
+}
+
+func TestCLIUsage(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"restart"},
+		{"stop"},
+		{"status", "--output", "yaml", testEC2ClientId},
+		{"status", "--log-format", "xml", testEC2ClientId},
+	} {
+		if code, _, _ := runTestCLI(t, &MockEC2Client{}, &MockSSMClient{}, nil, args...); code != exitUsage {
+			t.Fatalf("expected exit code %d for %v, got %d", exitUsage, args, code)
+		}
+	}
+	if code, _, _ := runTestCLI(t, &MockEC2Client{}, &MockSSMClient{}, map[string]string{"EC2_RUNNER_MAX_WAIT_SECS": "soon"}, "command", "--instance-id", testEC2ClientId, "true"); code != exitUsage {
+		t.Fatalf("expected exit code %d for an invalid environment variable, got %d", exitUsage, code)
+	}
+}
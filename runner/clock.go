@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time and waits. The clock in a context is used for all waiting and timestamps, so that
+// a RunnerManager's clock can be replaced, e.g. by tests.
+type Clock interface {
+	Now() time.Time
+	// Sleep pauses for duration d or until ctx is done, whichever happens first. It returns the context's
+	// error if ctx is done first.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// systemClock is the real time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type clockKey struct{}
+
+// withClock returns a copy of ctx that uses clock for waiting and timestamps.
+func withClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// clockFrom returns the clock of ctx, or the real time if it doesn't have one.
+func clockFrom(ctx context.Context) Clock {
+	if clock, ok := ctx.Value(clockKey{}).(Clock); ok {
+		return clock
+	}
+	return systemClock{}
+}
+
+// now returns the current time of the clock of ctx.
+func now(ctx context.Context) time.Time {
+	return clockFrom(ctx).Now()
+}
+
+// sleepContext pauses for duration d or until ctx is done, whichever happens first, using the clock of ctx.
+// It returns the context's error if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	return clockFrom(ctx).Sleep(ctx, d)
+}
@@ -0,0 +1,209 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// defaultOIDCAudience is the audience AWS expects in GitHub OIDC tokens exchanged for credentials.
+const defaultOIDCAudience = "sts.amazonaws.com"
+
+// maxSessionNameLength is the maximum length of an STS role session name.
+const maxSessionNameLength = 64
+
+// invalidSessionNameChars matches characters not allowed in an STS role session name.
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// IDTokenSource gets GitHub OIDC tokens for an audience. *githubactions.Action implements it with the token
+// endpoint of the job.
+type IDTokenSource interface {
+	GetIDToken(ctx context.Context, audience string) (string, error)
+}
+
+// webIdentityProvider is an aws.CredentialsProvider that exchanges a GitHub OIDC token for the credentials
+// of an IAM role. A new token is requested each time the credentials are retrieved, since tokens are short-lived.
+type webIdentityProvider struct {
+	logger      Logger
+	tokens      IDTokenSource
+	stsClient   STSAPI
+	roleArn     string
+	audience    string
+	sessionName string
+	duration    int
+}
+
+// NewWebIdentityCredentials returns a credentials provider for the IAM role roleArn, assumed with a GitHub OIDC
+// token for audience using AssumeRoleWithWebIdentity. The credentials last duration seconds and are cached until
+// they expire. The token is got from tokens. AssumeRoleWithWebIdentity only takes session tags from the token's
+// claims, so the role session is named after the workflow run and repository that getenv returns instead, which
+// identifies the run in CloudTrail.
+func NewWebIdentityCredentials(logger Logger, tokens IDTokenSource, getenv func(string) string, stsClient STSAPI, roleArn, audience string, duration int) aws.CredentialsProvider {
+	if audience == "" {
+		audience = defaultOIDCAudience
+	}
+	return aws.NewCredentialsCache(&webIdentityProvider{
+		logger:      logger,
+		tokens:      tokens,
+		stsClient:   stsClient,
+		roleArn:     roleArn,
+		audience:    audience,
+		sessionName: roleSessionName(getenv),
+		duration:    duration,
+	})
+}
+
+// Retrieve implements aws.CredentialsProvider.
+func (p *webIdentityProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	token, err := p.tokens.GetIDToken(ctx, p.audience)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("error getting GitHub OIDC token (does the job have the id-token: write permission?): %w", err)
+	}
+
+	resp, err := p.stsClient.AssumeRoleWithWebIdentity(ctx, &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleArn),
+		RoleSessionName:  aws.String(p.sessionName),
+		WebIdentityToken: aws.String(token),
+		DurationSeconds:  aws.Int32(int32(p.duration)),
+	})
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("error assuming role %s with GitHub OIDC token: %w", p.roleArn, err)
+	}
+	if resp.Credentials == nil {
+		return aws.Credentials{}, fmt.Errorf("no credentials returned assuming role %s", p.roleArn)
+	}
+
+	p.logger.AddMask(aws.ToString(resp.Credentials.SecretAccessKey))
+	p.logger.AddMask(aws.ToString(resp.Credentials.SessionToken))
+	p.logger.Infof("Assumed role %s with GitHub OIDC token (session %s)", p.roleArn, p.sessionName)
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(resp.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(resp.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(resp.Credentials.SessionToken),
+		Source:          "GitHubOIDC",
+		CanExpire:       true,
+		Expires:         aws.ToTime(resp.Credentials.Expiration),
+	}, nil
+}
+
+// roleSessionName returns a role session name identifying the workflow run, of the form "<run-id>@<owner>.<repo>",
+// from the variables getenv returns, with characters STS doesn't allow replaced and truncated to the maximum length.
+func roleSessionName(getenv func(string) string) string {
+	runId := getenv("GITHUB_RUN_ID")
+	repo := getenv("GITHUB_REPOSITORY")
+	name := "ec2-github-runner"
+	if runId != "" {
+		name = runId + "@" + repo
+	}
+	name = invalidSessionNameChars.ReplaceAllString(name, ".")
+	if len(name) > maxSessionNameLength {
+		name = name[:maxSessionNameLength]
+	}
+	return name
+}
+
+// assumeRoleProvider is an aws.CredentialsProvider for the credentials of an IAM role assumed with the
+// credentials of the STS client, which may themselves come from an assumed role.
+type assumeRoleProvider struct {
+	logger      Logger
+	stsClient   STSAPI
+	roleArn     string
+	externalId  string
+	sessionName string
+	sessionTags []stsTypes.Tag
+	duration    int
+}
+
+// Retrieve implements aws.CredentialsProvider.
+func (p *assumeRoleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	params := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(p.roleArn),
+		RoleSessionName: aws.String(p.sessionName),
+		DurationSeconds: aws.Int32(int32(p.duration)),
+		Tags:            p.sessionTags,
+	}
+	if p.externalId != "" {
+		params.ExternalId = aws.String(p.externalId)
+	}
+	resp, err := p.stsClient.AssumeRole(ctx, params)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	if resp.Credentials == nil {
+		return aws.Credentials{}, fmt.Errorf("no credentials returned assuming role %s", p.roleArn)
+	}
+
+	p.logger.AddMask(aws.ToString(resp.Credentials.SecretAccessKey))
+	p.logger.AddMask(aws.ToString(resp.Credentials.SessionToken))
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(resp.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(resp.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(resp.Credentials.SessionToken),
+		Source:          "AssumeRole",
+		CanExpire:       true,
+		Expires:         aws.ToTime(resp.Credentials.Expiration),
+	}, nil
+}
+
+// AssumeRoleChain assumes each of the IAM roles in roleArns in turn, starting with the credentials of cfg and
+// using the credentials of each role to assume the next, and returns a copy of cfg with the credentials of the
+// last role. newSTSClient creates the STS client for each hop. externalId is passed when assuming the last role,
+// which is usually the one in another account, and each role's credentials last duration seconds. The sessions are
+// named after the run and, if sessionTagging is set, tagged with the repository and run ID, which getenv returns and
+// the roles' trust policies must allow with sts:TagSession. Each role is assumed straight away, so an error names
+// the hop that failed, and the account and identity of the last role are logged with logger.
+func AssumeRoleChain(ctx context.Context, logger Logger, getenv func(string) string, cfg aws.Config, newSTSClient func(aws.Config) STSAPI, roleArns []string, externalId string, duration int, sessionTagging bool) (aws.Config, error) {
+	var tags []stsTypes.Tag
+	if sessionTagging {
+		tags = sessionTags(getenv)
+	}
+
+	for i, roleArn := range roleArns {
+		provider := &assumeRoleProvider{
+			logger:      logger,
+			stsClient:   newSTSClient(cfg),
+			roleArn:     roleArn,
+			sessionName: roleSessionName(getenv),
+			sessionTags: tags,
+			duration:    duration,
+		}
+		if i == len(roleArns)-1 {
+			provider.externalId = externalId
+		}
+
+		cfg = cfg.Copy()
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+		if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+			return cfg, fmt.Errorf("error assuming role %s (role %d of %d): %w", roleArn, i+1, len(roleArns), err)
+		}
+		logger.Infof("Assumed role %s (role %d of %d)", roleArn, i+1, len(roleArns))
+	}
+
+	identity, err := newSTSClient(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return cfg, fmt.Errorf("error getting caller identity: %w", err)
+	}
+	logger.Infof("Using AWS account %s as %s", aws.ToString(identity.Account), aws.ToString(identity.Arn))
+	return cfg, nil
+}
+
+// sessionTags returns the session tags identifying the repository and workflow run, from the variables getenv
+// returns.
+func sessionTags(getenv func(string) string) []stsTypes.Tag {
+	var tags []stsTypes.Tag
+	for _, tag := range []struct{ key, env string }{
+		{"Repository", "GITHUB_REPOSITORY"},
+		{"RunId", "GITHUB_RUN_ID"},
+	} {
+		if value := getenv(tag.env); value != "" {
+			tags = append(tags, stsTypes.Tag{Key: aws.String(tag.key), Value: aws.String(value)})
+		}
+	}
+	return tags
+}
@@ -0,0 +1,225 @@
+package runner
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// MockSTSClient is a stand-in for STS that returns credentials for the roles it is asked to assume.
+type MockSTSClient struct {
+	webIdentityInputs []*sts.AssumeRoleWithWebIdentityInput
+	// callerKey is the access key ID of the credentials the client was created with.
+	callerKey string
+	// hops records each AssumeRole call as "<caller key> -> <role ARN>".
+	hops        *[]string
+	assumeInput []*sts.AssumeRoleInput
+	// denied is a role ARN that AssumeRole refuses.
+	denied string
+	// decodedMessage is returned by DecodeAuthorizationMessage.
+	decodedMessage string
+}
+
+func (m *MockSTSClient) DecodeAuthorizationMessage(ctx context.Context, params *sts.DecodeAuthorizationMessageInput, optFns ...func(*sts.Options)) (*sts.DecodeAuthorizationMessageOutput, error) {
+	if m.decodedMessage == "" {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:DecodeAuthorizationMessage"}
+	}
+	return &sts.DecodeAuthorizationMessageOutput{DecodedMessage: aws.String(m.decodedMessage)}, nil
+}
+
+func (m *MockSTSClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	if *params.RoleArn == m.denied {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:AssumeRole"}
+	}
+	*m.hops = append(*m.hops, m.callerKey+" -> "+*params.RoleArn)
+	m.assumeInput = append(m.assumeInput, params)
+	return &sts.AssumeRoleOutput{
+		Credentials: &stsTypes.Credentials{
+			AccessKeyId:     aws.String("key-for-" + *params.RoleArn),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("session-token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+func (m *MockSTSClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	account := strings.Split(m.callerKey, ":")[4]
+	return &sts.GetCallerIdentityOutput{Account: aws.String(account), Arn: aws.String(m.callerKey)}, nil
+}
+
+func (m *MockSTSClient) AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	m.webIdentityInputs = append(m.webIdentityInputs, params)
+	return &sts.AssumeRoleWithWebIdentityOutput{
+		Credentials: &stsTypes.Credentials{
+			AccessKeyId:     aws.String("AKIAEXAMPLE"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("session-token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+// newOIDCTestAction returns an action whose OIDC token requests go to a local fake token endpoint.
+func newOIDCTestAction(t *testing.T, env map[string]string) *githubactions.Action {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"value":"oidc-token-for-` + r.URL.Query().Get("audience") + `"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	env["ACTIONS_ID_TOKEN_REQUEST_URL"] = server.URL
+	env["ACTIONS_ID_TOKEN_REQUEST_TOKEN"] = "request-token"
+	return githubactions.New(githubactions.WithGetenv(func(key string) string { return env[key] }))
+}
+
+func TestWebIdentityCredentials(t *testing.T) {
+	action := newOIDCTestAction(t, map[string]string{
+		"GITHUB_RUN_ID":     "1234",
+		"GITHUB_REPOSITORY": "octo-org/octo-repo",
+	})
+	mockSTS := &MockSTSClient{}
+
+	ctx := context.Background()
+
+	provider := NewWebIdentityCredentials(action, action, action.Getenv, mockSTS, "arn:aws:iam::123456789012:role/runner", "", 900)
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if creds.AccessKeyID != "AKIAEXAMPLE" || creds.SessionToken != "session-token" || !creds.CanExpire {
+		t.Fatalf("expected credentials from STS, got %+v", creds)
+	}
+
+	if len(mockSTS.webIdentityInputs) != 1 {
+		t.Fatalf("expected 1 AssumeRoleWithWebIdentity call, got %d", len(mockSTS.webIdentityInputs))
+	}
+	input := mockSTS.webIdentityInputs[0]
+	if *input.WebIdentityToken != "oidc-token-for-sts.amazonaws.com" {
+		t.Fatalf("expected token for the default audience, got %s", *input.WebIdentityToken)
+	}
+	if *input.RoleSessionName != "1234@octo-org.octo-repo" {
+		t.Fatalf("expected session name 1234@octo-org.octo-repo, got %s", *input.RoleSessionName)
+	}
+	if *input.DurationSeconds != 900 {
+		t.Fatalf("expected duration 900, got %d", *input.DurationSeconds)
+	}
+
+	// The credentials are cached until they expire.
+	if _, err := provider.Retrieve(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(mockSTS.webIdentityInputs) != 1 {
+		t.Fatalf("expected cached credentials, got %d AssumeRoleWithWebIdentity calls", len(mockSTS.webIdentityInputs))
+	}
+}
+
+func TestWebIdentityCredentialsNoToken(t *testing.T) {
+	action := githubactions.New(githubactions.WithGetenv(func(key string) string { return "" }))
+	mockSTS := &MockSTSClient{}
+
+	ctx := context.Background()
+
+	provider := NewWebIdentityCredentials(action, action, action.Getenv, mockSTS, "arn:aws:iam::123456789012:role/runner", "", 900)
+	if _, err := provider.Retrieve(ctx); err == nil {
+		t.Fatalf("expected error without an OIDC token endpoint")
+	}
+	if len(mockSTS.webIdentityInputs) != 0 {
+		t.Fatalf("expected no AssumeRoleWithWebIdentity calls, got %d", len(mockSTS.webIdentityInputs))
+	}
+}
+
+func TestRoleSessionName(t *testing.T) {
+	env := map[string]string{"GITHUB_RUN_ID": "98765", "GITHUB_REPOSITORY": "org/" + string(make([]byte, 80))}
+	name := roleSessionName(func(key string) string { return env[key] })
+	if len(name) != maxSessionNameLength {
+		t.Fatalf("expected session name truncated to %d characters, got %d", maxSessionNameLength, len(name))
+	}
+	if invalidSessionNameChars.MatchString(name) {
+		t.Fatalf("expected only valid characters in session name, got %q", name)
+	}
+}
+
+// newChainTestSTS returns an STS client factory whose clients record which credentials each role was assumed with.
+func newChainTestSTS(hops *[]string, denied string, clients *[]*MockSTSClient) func(aws.Config) STSAPI {
+	return func(cfg aws.Config) STSAPI {
+		creds, err := cfg.Credentials.Retrieve(context.Background())
+		if err != nil {
+			panic(err)
+		}
+		client := &MockSTSClient{callerKey: strings.TrimPrefix(creds.AccessKeyID, "key-for-"), hops: hops, denied: denied}
+		*clients = append(*clients, client)
+		return client
+	}
+}
+
+func TestAssumeRoleChain(t *testing.T) {
+	getenv := func(key string) string {
+		return map[string]string{"GITHUB_RUN_ID": "1234", "GITHUB_REPOSITORY": "octo-org/octo-repo"}[key]
+	}
+	cfg := aws.Config{Credentials: aws.NewCredentialsCache(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "arn:aws:iam::111111111111:role/hub"}, nil
+	}))}
+	roles := []string{"arn:aws:iam::222222222222:role/ci", "arn:aws:iam::333333333333:role/runner"}
+
+	ctx := context.Background()
+
+	var hops []string
+	var clients []*MockSTSClient
+	cfg, err := AssumeRoleChain(ctx, nopLogger{}, getenv, cfg, newChainTestSTS(&hops, "", &clients), roles, "external-id", 3600, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	want := []string{
+		"arn:aws:iam::111111111111:role/hub -> arn:aws:iam::222222222222:role/ci",
+		"arn:aws:iam::222222222222:role/ci -> arn:aws:iam::333333333333:role/runner",
+	}
+	if strings.Join(hops, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected hops %v, got %v", want, hops)
+	}
+
+	creds, _ := cfg.Credentials.Retrieve(ctx)
+	if creds.AccessKeyID != "key-for-arn:aws:iam::333333333333:role/runner" {
+		t.Fatalf("expected credentials of the last role, got %s", creds.AccessKeyID)
+	}
+
+	first, last := clients[0].assumeInput[0], clients[1].assumeInput[0]
+	if first.ExternalId != nil || aws.ToString(last.ExternalId) != "external-id" {
+		t.Fatalf("expected external ID only for the last role, got %v and %v", first.ExternalId, last.ExternalId)
+	}
+	if len(last.Tags) != 2 || *last.Tags[0].Value != "octo-org/octo-repo" || *last.Tags[1].Value != "1234" {
+		t.Fatalf("expected repository and run ID session tags, got %v", last.Tags)
+	}
+}
+
+func TestAssumeRoleChainDenied(t *testing.T) {
+	cfg := aws.Config{Credentials: aws.NewCredentialsCache(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "arn:aws:iam::111111111111:user/ci"}, nil
+	}))}
+	roles := []string{"arn:aws:iam::222222222222:role/ci", "arn:aws:iam::333333333333:role/runner"}
+
+	ctx := context.Background()
+
+	var hops []string
+	var clients []*MockSTSClient
+	_, err := AssumeRoleChain(ctx, nopLogger{}, func(string) string { return "" }, cfg, newChainTestSTS(&hops, roles[1], &clients), roles, "", 3600, false)
+	if err == nil || !strings.Contains(err.Error(), "role 2 of 2") || !strings.Contains(err.Error(), roles[1]) {
+		t.Fatalf("expected error naming the second role, got %v", err)
+	}
+	if clients[0].assumeInput[0].Tags != nil {
+		t.Fatalf("expected no session tags, got %v", clients[0].assumeInput[0].Tags)
+	}
+}
@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// consoleTailLines is the number of console output lines printed to the log; the full output is saved to a file.
+const consoleTailLines = 50
+
+// CollectInstanceDiagnostics saves the console output and a screenshot of an EC2 instance into dir, so they can be
+// uploaded as an artifact, and prints the tail of the console output in a collapsed group. It is used when an
+// instance fails to launch or become ready. It returns the paths of the files saved, and an error only if none
+// of the diagnostics could be collected.
+func CollectInstanceDiagnostics(ctx context.Context, logger Logger, ec2Client EC2API, instanceId, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating diagnostics directory %s: %w", dir, err)
+	}
+
+	var saved []string
+	var errs []string
+
+	consoleOutput, err := getConsoleOutput(ctx, ec2Client, instanceId)
+	switch {
+	case err != nil:
+		errs = append(errs, err.Error())
+	case consoleOutput == "":
+		logger.Infof("Console output of instance %s is not available yet", instanceId)
+	default:
+		path := filepath.Join(dir, instanceId+"-console.log")
+		if err := os.WriteFile(path, []byte(consoleOutput), 0o644); err != nil {
+			errs = append(errs, fmt.Sprintf("error saving console output: %v", err))
+		} else {
+			saved = append(saved, path)
+		}
+
+		logger.Group(fmt.Sprintf("Console output of instance %s (last %d lines)", instanceId, consoleTailLines))
+		logger.Infof("%s", tailLines(consoleOutput, consoleTailLines))
+		logger.EndGroup()
+	}
+
+	screenshot, err := getConsoleScreenshot(ctx, ec2Client, instanceId)
+	if err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		path := filepath.Join(dir, instanceId+"-screenshot.jpg")
+		if err := os.WriteFile(path, screenshot, 0o644); err != nil {
+			errs = append(errs, fmt.Sprintf("error saving console screenshot: %v", err))
+		} else {
+			saved = append(saved, path)
+		}
+	}
+
+	for _, e := range errs {
+		logger.Warningf("%s", e)
+	}
+	if len(saved) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("error collecting diagnostics for instance %s", instanceId)
+	}
+	if len(saved) > 0 {
+		logger.Infof("Saved diagnostics for instance %s to %s", instanceId, strings.Join(saved, ", "))
+	}
+	return saved, nil
+}
+
+// getConsoleOutput returns the decoded console output of an EC2 instance, or "" if there is none yet.
+func getConsoleOutput(ctx context.Context, ec2Client EC2API, instanceId string) (string, error) {
+	resp, err := ec2Client.GetConsoleOutput(ctx, &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceId),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting console output of instance %s: %w", instanceId, err)
+	}
+	output, err := base64.StdEncoding.DecodeString(aws.ToString(resp.Output))
+	if err != nil {
+		return "", fmt.Errorf("error decoding console output of instance %s: %w", instanceId, err)
+	}
+	return string(output), nil
+}
+
+// getConsoleScreenshot returns a JPG screenshot of the console of an EC2 instance.
+func getConsoleScreenshot(ctx context.Context, ec2Client EC2API, instanceId string) ([]byte, error) {
+	resp, err := ec2Client.GetConsoleScreenshot(ctx, &ec2.GetConsoleScreenshotInput{
+		InstanceId: aws.String(instanceId),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting console screenshot of instance %s: %w", instanceId, err)
+	}
+	image, err := base64.StdEncoding.DecodeString(aws.ToString(resp.ImageData))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding console screenshot of instance %s: %w", instanceId, err)
+	}
+	return image, nil
+}
+
+// tailLines returns the last n lines of s.
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
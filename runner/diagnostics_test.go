@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/smithy-go"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// ConsoleOutputEC2Client returns output as the instance's console output.
+type ConsoleOutputEC2Client struct {
+	MockEC2Client
+	output string
+}
+
+func (m *ConsoleOutputEC2Client) GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error) {
+	return &ec2.GetConsoleOutputOutput{
+		InstanceId: aws.String(testEC2ClientId),
+		Output:     aws.String(base64.StdEncoding.EncodeToString([]byte(m.output))),
+	}, nil
+}
+
+// NoScreenshotEC2Client is an instance type that doesn't support console screenshots.
+type NoScreenshotEC2Client struct {
+	MockEC2Client
+}
+
+func (m *NoScreenshotEC2Client) GetConsoleScreenshot(ctx context.Context, params *ec2.GetConsoleScreenshotInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleScreenshotOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "UnsupportedOperation", Message: "not supported"}
+}
+
+func TestCollectInstanceDiagnostics(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 100; i++ {
+		lines = append(lines, fmt.Sprintf("boot line %d", i))
+	}
+	consoleOutput := strings.Join(lines, "\n")
+
+	tests := []struct {
+		name   string
+		client EC2API
+		// saved are the files expected to be saved, or nil if an error is expected.
+		saved []string
+	}{
+		{"console output and screenshot", &ConsoleOutputEC2Client{output: consoleOutput}, []string{testEC2ClientId + "-console.log", testEC2ClientId + "-screenshot.jpg"}},
+		{"screenshot only", &MockEC2Client{}, []string{testEC2ClientId + "-screenshot.jpg"}},
+		{"nothing available", &NoScreenshotEC2Client{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "diagnostics")
+			saved, err := CollectInstanceDiagnostics(context.Background(), githubactions.New(), tt.client, testEC2ClientId, dir)
+			if tt.saved == nil {
+				if err == nil {
+					t.Fatalf("expected an error when no diagnostics are available, got %v", saved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			var names []string
+			for _, path := range saved {
+				names = append(names, filepath.Base(path))
+			}
+			if !reflect.DeepEqual(names, tt.saved) {
+				t.Fatalf("expected %v to be saved, got %v", tt.saved, names)
+			}
+		})
+	}
+
+	// The full console output is saved, although only its tail is logged.
+	dir := t.TempDir()
+	if _, err := CollectInstanceDiagnostics(context.Background(), githubactions.New(), &ConsoleOutputEC2Client{output: consoleOutput}, testEC2ClientId, dir); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, testEC2ClientId+"-console.log")); err != nil || string(data) != consoleOutput {
+		t.Fatalf("expected the full console output to be saved, got %d bytes, %v", len(data), err)
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	got := tailLines("a\nb\nc\nd\n", 2)
+	if got != "c\nd" {
+		t.Fatalf("expected last 2 lines, got %q", got)
+	}
+}
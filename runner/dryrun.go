@@ -0,0 +1,211 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// modePermissions are the IAM actions each mode needs, other than those checked with EC2 dry run requests.
+// The actions start needs depend on its inputs and are returned by startPermissions.
+var modePermissions = map[string][]string{
+	"command":            {"ssm:SendCommand", "ssm:GetCommandInvocation", "ssm:DescribeInstanceInformation", "ssm:CancelCommand"},
+	"wait-command":       {"ssm:GetCommandInvocation", "ssm:CancelCommand"},
+	"copy-to-instance":   {"s3:PutObject", "s3:GetObject", "s3:DeleteObject", "ssm:SendCommand", "ssm:GetCommandInvocation", "ssm:DescribeInstanceInformation", "ssm:CancelCommand"},
+	"copy-from-instance": {"s3:PutObject", "s3:GetObject", "s3:DeleteObject", "ssm:SendCommand", "ssm:GetCommandInvocation", "ssm:DescribeInstanceInformation", "ssm:CancelCommand"},
+}
+
+// startPermissions returns the IAM actions start needs with the given inputs, other than ec2:RunInstances,
+// which is checked with a dry run request.
+func startPermissions(readiness []ReadinessCondition, iamRoleName string, createIamRole, terminateOnCancel bool) []string {
+	actions := []string{"ec2:DescribeInstances", "ec2:GetConsoleOutput", "ec2:GetConsoleScreenshot"}
+	for _, condition := range readiness {
+		switch condition.Name {
+		case ReadyStatusOk:
+			actions = append(actions, "ec2:DescribeInstanceStatus")
+		case ReadySSMOnline:
+			actions = append(actions, "ssm:DescribeInstanceInformation")
+		case ReadyUserDataComplete:
+			actions = append(actions, "ssm:SendCommand", "ssm:GetCommandInvocation")
+		}
+	}
+	if iamRoleName != "" {
+		actions = append(actions, "iam:ListInstanceProfilesForRole", "iam:GetInstanceProfile", "iam:CreateInstanceProfile", "iam:AddRoleToInstanceProfile", "iam:PassRole")
+	}
+	if createIamRole {
+		actions = append(actions, "iam:GetRole", "iam:CreateRole", "iam:TagRole", "iam:AttachRolePolicy", "iam:PutRolePolicy")
+	}
+	if terminateOnCancel {
+		actions = append(actions, "ec2:TerminateInstances")
+	}
+	return actions
+}
+
+// DryRunStart checks that an instance could be launched in each of the targets without launching one. It sends
+// RunInstances as a dry run, which validates the parameters and the ec2:RunInstances permission, using the
+// role's existing instance profile if it has one. Nothing is created. It returns the problems found.
+func DryRunStart(ctx context.Context, logger Logger, newEC2Client func(region string) EC2API, iamClient IAMAPI, targets []RegionTarget, spec LaunchSpec) []string {
+	var problems []string
+
+	var instanceProfileName string
+	if spec.IAMRoleName != "" {
+		var err error
+		instanceProfileName, err = findInstanceProfile(ctx, iamClient, spec.IAMRoleName)
+		switch {
+		case isErrorCode(err, "NoSuchEntity") && spec.CreateIAMRole:
+			logger.Infof("IAM role %s does not exist and would be created", spec.IAMRoleName)
+		case err != nil:
+			problems = append(problems, err.Error())
+		case instanceProfileName == "":
+			logger.Infof("Instance profile for IAM role %s does not exist and would be created", spec.IAMRoleName)
+		}
+	}
+
+	for _, target := range targets {
+		spec.ImageId, spec.SubnetId, spec.SecurityGroupId = target.ImageId, target.SubnetId, target.SecurityGroupId
+		params := newRunInstancesInput(spec)
+		if instanceProfileName != "" {
+			params.IamInstanceProfile = &ec2Types.IamInstanceProfileSpecification{Name: aws.String(instanceProfileName)}
+		}
+		params.DryRun = aws.Bool(true)
+
+		_, err := newEC2Client(target.Region).RunInstances(ctx, params)
+		if err := dryRunError(err); err != nil {
+			problems = append(problems, fmt.Sprintf("RunInstances in region %s: %v", target.Region, err))
+			continue
+		}
+		logger.Infof("RunInstances dry run succeeded in region %s", target.Region)
+	}
+	return problems
+}
+
+// DryRunInstanceCommand checks that an SSM command could be sent to an EC2 instance, by checking that its SSM agent
+// is online. It returns the problems found.
+func DryRunInstanceCommand(ctx context.Context, logger Logger, ssmClient SSMAPI, ec2InstanceId string) []string {
+	online, err := IsSSMAgentRegistered(ctx, logger, ssmClient, ec2InstanceId, 1, 1)
+	if err != nil {
+		return []string{fmt.Sprintf("error checking SSM agent of instance %s: %v", ec2InstanceId, err)}
+	}
+	if !online {
+		return []string{fmt.Sprintf("SSM agent is not registered or online for instance %s", ec2InstanceId)}
+	}
+	return nil
+}
+
+// DryRunCopyToInstance checks that the local paths to copy to an instance exist, by archiving them without
+// keeping the archive. It returns the problems found.
+func DryRunCopyToInstance(logger Logger, localPaths []string, baseDir string) []string {
+	count, err := createArchive(io.Discard, baseDir, localPaths)
+	if err != nil {
+		return []string{fmt.Sprintf("error archiving local paths: %v", err)}
+	}
+	logger.Infof("%d files would be copied", count)
+	return nil
+}
+
+// DryRunTerminate checks that an EC2 instance exists and hasn't already been terminated, and sends
+// TerminateInstances for it as a dry run, which checks the ec2:TerminateInstances permission. It returns the
+// problems found.
+func DryRunTerminate(ctx context.Context, logger Logger, ec2Client EC2API, ec2InstanceId string) []string {
+	var problems []string
+	instance, err := DescribeEC2Instance(ctx, ec2Client, ec2InstanceId)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if state := instance.State; state != nil && (state.Name == ec2Types.InstanceStateNameShuttingDown || state.Name == ec2Types.InstanceStateNameTerminated) {
+		problems = append(problems, fmt.Sprintf("instance %s is already %s", ec2InstanceId, state.Name))
+	}
+
+	_, err = ec2Client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []string{ec2InstanceId},
+		DryRun:      aws.Bool(true),
+	})
+	if err := dryRunError(err); err != nil {
+		return append(problems, fmt.Sprintf("TerminateInstances for instance %s: %v", ec2InstanceId, err))
+	}
+	logger.Infof("TerminateInstances dry run succeeded for instance %s", ec2InstanceId)
+	return problems
+}
+
+// CheckPermissions simulates the IAM policies of the caller's principal for each of the actions, and returns a
+// problem for each action that isn't allowed. Actions are simulated against all resources, so permissions granted
+// only for specific resources may be reported as denied. If the policies can't be simulated, e.g. because the
+// caller isn't allowed iam:SimulatePrincipalPolicy, a warning is logged and no problems are returned.
+func CheckPermissions(ctx context.Context, logger Logger, iamClient IAMAPI, stsClient STSAPI, actions []string) []string {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		logger.Warningf("Could not check permissions, error getting caller identity: %v", err)
+		return nil
+	}
+	principal := principalArn(aws.ToString(identity.Arn))
+
+	var problems []string
+	paginator := iam.NewSimulatePrincipalPolicyPaginator(iamClient, &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principal),
+		ActionNames:     actions,
+	})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			logger.Warningf("Could not check permissions of %s: %v", principal, err)
+			return nil
+		}
+		for _, result := range resp.EvaluationResults {
+			if result.EvalDecision != iamTypes.PolicyEvaluationDecisionTypeAllowed {
+				problems = append(problems, fmt.Sprintf("%s is not allowed %s (%s)", principal, aws.ToString(result.EvalActionName), result.EvalDecision))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		logger.Infof("%s is allowed %s", principal, strings.Join(actions, ", "))
+	}
+	return problems
+}
+
+// ReportDryRun logs the outcome of a dry run, and returns an error listing the problems found, if any.
+func ReportDryRun(logger Logger, mode string, problems []string) error {
+	if len(problems) > 0 {
+		return fmt.Errorf("Dry run of %s mode found %s", mode, listProblems(problems))
+	}
+	logger.Infof("Dry run of %s mode succeeded", mode)
+	return nil
+}
+
+// dryRunError interprets the result of an EC2 dry run request: DryRunOperation means the request would have
+// succeeded and nil is returned, UnauthorizedOperation means the caller lacks permission, and any other error
+// means the parameters are invalid.
+func dryRunError(err error) error {
+	switch {
+	case err == nil:
+		return fmt.Errorf("request was not treated as a dry run")
+	case isErrorCode(err, "DryRunOperation"):
+		return nil
+	case isErrorCode(err, "UnauthorizedOperation"):
+		return fmt.Errorf("not authorized: %w", err)
+	default:
+		return err
+	}
+}
+
+// principalArn returns the ARN of the IAM principal for a caller identity ARN. IAM policies can't be simulated
+// for a role session, so "arn:aws:sts::<account>:assumed-role/<role>/<session>" becomes the role's ARN. Roles
+// with a path can't be identified this way.
+func principalArn(callerArn string) string {
+	parts := strings.SplitN(callerArn, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return callerArn
+	}
+	role := strings.Split(strings.TrimPrefix(parts[5], "assumed-role/"), "/")[0]
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], role)
+}
@@ -0,0 +1,142 @@
+package runner
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// DryRunErrorEC2Client fails dry run requests with err, as EC2 does when the request wouldn't succeed.
+type DryRunErrorEC2Client struct {
+	MockEC2Client
+	err error
+}
+
+func (m *DryRunErrorEC2Client) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	return nil, m.err
+}
+
+func (m *DryRunErrorEC2Client) TerminateInstances(ctx context.Context, params *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	return nil, m.err
+}
+
+// DenyingIAMClient denies the actions in denied when simulating policies, and records the last simulation.
+type DenyingIAMClient struct {
+	MockIAMClient
+	denied    []string
+	simulated *iam.SimulatePrincipalPolicyInput
+}
+
+func (m *DenyingIAMClient) SimulatePrincipalPolicy(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+	m.simulated = params
+	var results []iamTypes.EvaluationResult
+	for _, actionName := range params.ActionNames {
+		decision := iamTypes.PolicyEvaluationDecisionTypeAllowed
+		if slices.Contains(m.denied, actionName) {
+			decision = iamTypes.PolicyEvaluationDecisionTypeImplicitDeny
+		}
+		results = append(results, iamTypes.EvaluationResult{EvalActionName: aws.String(actionName), EvalDecision: decision})
+	}
+	return &iam.SimulatePrincipalPolicyOutput{EvaluationResults: results}, nil
+}
+
+func TestDryRunStart(t *testing.T) {
+	targets := []RegionTarget{{Region: "us-east-1", ImageId: "ami-12345678", SubnetId: "subnet-12345678", SecurityGroupId: "sg-12345678"}}
+	unauthorized := &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "You are not authorized to perform this operation."}
+
+	tests := []struct {
+		name   string
+		client EC2API
+		spec   LaunchSpec
+		// problem is a substring of the one problem expected, or empty if none are expected.
+		problem string
+	}{
+		{"allowed", &MockEC2Client{}, LaunchSpec{IAMRoleName: "test-role", InstanceType: "t3.micro"}, ""},
+		{"not authorized", &DryRunErrorEC2Client{err: unauthorized}, LaunchSpec{InstanceType: "t3.micro"}, "not authorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockIAM := &MockIAMClient{}
+			newEC2Client := func(region string) EC2API { return tt.client }
+			problems := DryRunStart(context.Background(), githubactions.New(), newEC2Client, mockIAM, targets, tt.spec)
+			checkProblems(t, problems, tt.problem)
+			if len(mockIAM.created) != 0 {
+				t.Fatalf("expected no instance profile to be created, got %v", mockIAM.created)
+			}
+		})
+	}
+}
+
+func TestDryRunTerminate(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  EC2API
+		problem string
+	}{
+		{"allowed", &MockEC2Client{}, ""},
+		{"malformed instance ID", &DryRunErrorEC2Client{err: &smithy.GenericAPIError{Code: "InvalidInstanceID.Malformed", Message: "Invalid id"}}, "InvalidInstanceID.Malformed"},
+		{"instance not found", &InstanceStatesEC2Client{notFound: 1}, "InvalidInstanceID.NotFound"},
+		{"instance terminated", &InstanceStatesEC2Client{states: []ec2Types.InstanceStateName{ec2Types.InstanceStateNameTerminated}}, "already terminated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkProblems(t, DryRunTerminate(context.Background(), githubactions.New(), tt.client, testEC2ClientId), tt.problem)
+		})
+	}
+}
+
+// checkProblems fails the test unless problems is one problem containing problem, or none if problem is empty.
+func checkProblems(t *testing.T, problems []string, problem string) {
+	t.Helper()
+	if problem == "" && len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+	if problem != "" && (len(problems) != 1 || !strings.Contains(problems[0], problem)) {
+		t.Fatalf("expected a problem containing %q, got %v", problem, problems)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	mockIAM := &DenyingIAMClient{denied: []string{"ssm:CancelCommand"}}
+	mockSTS := &MockSTSClient{callerKey: "arn:aws:sts::123456789012:assumed-role/runner/1234"}
+
+	problems := CheckPermissions(context.Background(), githubactions.New(), mockIAM, mockSTS, modePermissions["wait-command"])
+	checkProblems(t, problems, "ssm:CancelCommand")
+	if *mockIAM.simulated.PolicySourceArn != "arn:aws:iam::123456789012:role/runner" {
+		t.Fatalf("expected policies of the role to be simulated, got %s", *mockIAM.simulated.PolicySourceArn)
+	}
+}
+
+func TestReportDryRun(t *testing.T) {
+	action := githubactions.New()
+
+	if err := ReportDryRun(action, "stop", nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err := ReportDryRun(action, "stop", []string{"first", "second"})
+	if err == nil || !strings.Contains(err.Error(), "2 problem(s)") || !strings.Contains(err.Error(), "- second") {
+		t.Fatalf("expected error listing both problems, got %v", err)
+	}
+}
+
+func TestPrincipalArn(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:sts::123456789012:assumed-role/runner/1234@octo-org.octo-repo": "arn:aws:iam::123456789012:role/runner",
+		"arn:aws-cn:sts::123456789012:assumed-role/runner/session":              "arn:aws-cn:iam::123456789012:role/runner",
+		"arn:aws:iam::123456789012:user/ci":                                     "arn:aws:iam::123456789012:user/ci",
+	}
+	for callerArn, want := range tests {
+		if got := principalArn(callerArn); got != want {
+			t.Fatalf("expected %s for %s, got %s", want, callerArn, got)
+		}
+	}
+}
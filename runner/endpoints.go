@@ -0,0 +1,54 @@
+package runner
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// endpointServices are the services whose endpoints can be overridden, as used in the <service>-endpoint-url inputs.
+var endpointServices = []string{"ec2", "ssm", "iam", "sts", "s3"}
+
+// Endpoints are the custom endpoint settings of the AWS clients, e.g. for a local AWS emulator or VPC endpoints.
+type Endpoints struct {
+	// URL is the endpoint of every service that doesn't have its own in Services.
+	URL string
+	// Services are the endpoints of individual services, by the names in endpointServices.
+	Services  map[string]string
+	FIPS      bool
+	DualStack bool
+}
+
+// apply sets the endpoint options of a client of service: its custom endpoint URL if it has one, and FIPS and
+// dual-stack endpoints if they are enabled. A custom endpoint, including one set with the AWS_ENDPOINT_URL
+// environment variables, is used as given, so FIPS and dual-stack endpoints are disabled for it.
+func (e Endpoints) apply(service string, baseEndpoint **string, fips *aws.FIPSEndpointState, dualStack *aws.DualStackEndpointState) {
+	if endpoint := e.Services[service]; endpoint != "" {
+		*baseEndpoint = aws.String(endpoint)
+	} else if e.URL != "" {
+		*baseEndpoint = aws.String(e.URL)
+	}
+	if e.FIPS {
+		*fips = aws.FIPSEndpointStateEnabled
+	}
+	if e.DualStack {
+		*dualStack = aws.DualStackEndpointStateEnabled
+	}
+	if *baseEndpoint != nil {
+		*fips = aws.FIPSEndpointStateDisabled
+		*dualStack = aws.DualStackEndpointStateDisabled
+	}
+}
+
+// validateEndpointURL checks that endpoint is an absolute http or https URL.
+func validateEndpointURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", endpoint)
+	}
+	return nil
+}
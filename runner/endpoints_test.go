@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestEndpointsApply(t *testing.T) {
+	endpoints := Endpoints{URL: "http://localhost:4566", Services: map[string]string{"s3": "https://bucket.vpce-1.s3.us-east-1.vpce.amazonaws.com"}, FIPS: true}
+
+	var baseEndpoint *string
+	fips, dualStack := aws.FIPSEndpointStateUnset, aws.DualStackEndpointStateUnset
+	endpoints.apply("s3", &baseEndpoint, &fips, &dualStack)
+	if aws.ToString(baseEndpoint) != endpoints.Services["s3"] {
+		t.Fatalf("expected the S3 endpoint, got %v", aws.ToString(baseEndpoint))
+	}
+	if fips != aws.FIPSEndpointStateDisabled {
+		t.Fatalf("expected FIPS to be disabled for a custom endpoint, got %v", fips)
+	}
+
+	baseEndpoint = nil
+	endpoints.apply("ec2", &baseEndpoint, &fips, &dualStack)
+	if aws.ToString(baseEndpoint) != endpoints.URL {
+		t.Fatalf("expected the default endpoint, got %v", aws.ToString(baseEndpoint))
+	}
+}
+
+func TestEndpointsApplyFIPSAndDualStack(t *testing.T) {
+	endpoints := Endpoints{FIPS: true, DualStack: true}
+
+	var baseEndpoint *string
+	fips, dualStack := aws.FIPSEndpointStateUnset, aws.DualStackEndpointStateUnset
+	endpoints.apply("ec2", &baseEndpoint, &fips, &dualStack)
+	if baseEndpoint != nil || fips != aws.FIPSEndpointStateEnabled || dualStack != aws.DualStackEndpointStateEnabled {
+		t.Fatalf("expected FIPS and dual-stack endpoints, got %v, %v, %v", baseEndpoint, fips, dualStack)
+	}
+
+	// Settings from the environment are kept when the inputs aren't set.
+	fips = aws.FIPSEndpointStateEnabled
+	Endpoints{}.apply("ec2", &baseEndpoint, &fips, &dualStack)
+	if fips != aws.FIPSEndpointStateEnabled {
+		t.Fatalf("expected FIPS to stay enabled, got %v", fips)
+	}
+}
+
+func TestValidateEndpointURL(t *testing.T) {
+	for _, endpoint := range []string{"http://localhost:4566", "https://vpce-1.ec2.us-east-1.vpce.amazonaws.com"} {
+		if err := validateEndpointURL(endpoint); err != nil {
+			t.Fatalf("expected %s to be valid, got %v", endpoint, err)
+		}
+	}
+	for _, endpoint := range []string{"localhost:4566", "ftp://localhost", "https://", "http://[::1"} {
+		if err := validateEndpointURL(endpoint); err == nil {
+			t.Fatalf("expected an error for %s", endpoint)
+		}
+	}
+}
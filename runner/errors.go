@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+// encodedMessagePrefix precedes the encoded authorization message in EC2 UnauthorizedOperation errors.
+const encodedMessagePrefix = "Encoded authorization failure message:"
+
+// errorHints are suggestions for fixing common AWS errors, by error code.
+var errorHints = map[string]string{
+	"UnauthorizedOperation":       "The credentials are missing an IAM permission needed by this mode, see the IAM Permissions section of the README.",
+	"AccessDenied":                "The credentials are missing an IAM permission needed by this mode, see the IAM Permissions section of the README.",
+	"AccessDeniedException":       "The credentials are missing an IAM permission needed by this mode, see the IAM Permissions section of the README.",
+	"InvalidAMIID.NotFound":       "Check ec2-image-id. AMIs are specific to a region, and a private AMI must be shared with the account.",
+	"InvalidAMIID.Malformed":      "Check ec2-image-id, which should look like ami-0123456789abcdef0.",
+	"InvalidSubnetID.NotFound":    "Check subnet-id. Subnets are specific to a region and account.",
+	"InvalidGroup.NotFound":       "Check security-group-id. Security groups are specific to a region and VPC.",
+	"InvalidInstanceID.NotFound":  "Check ec2-instance-id, and that aws-region is the region the instance was started in.",
+	"InvalidInstanceID.Malformed": "Check ec2-instance-id, which should look like i-0123456789abcdef0.",
+	"InvalidInstanceId":           "The instance isn't managed by SSM. Check that it is running, its SSM agent is online and its instance profile has AmazonSSMManagedInstanceCore.",
+	"NoSuchBucket":                "Check s3-bucket, and s3-endpoint-url if the bucket isn't in AWS S3.",
+	"InvalidIdentityToken":        "Check audience, and that the trust policy of role-to-assume allows the repository's GitHub OIDC token.",
+	"InvalidParameterCombination": "Some of the launch parameters can't be used together, e.g. the instance type with the AMI's architecture or the subnet's availability zone. The preflight checks of start mode report the common cases.",
+}
+
+// AWSError is an AWS API error with the operation that failed, a hint for fixing common errors and, for
+// authorization failures, the decoded action and resource that were denied.
+type AWSError struct {
+	// Operation is the failed call, e.g. "ec2:RunInstances".
+	Operation string
+	Code      string
+	Hint      string
+	// Authorization is the decoded authorization failure of an UnauthorizedOperation error, if it was decoded.
+	Authorization *AuthorizationFailure
+	err           error
+}
+
+// AuthorizationFailure describes why a request was denied, as decoded by sts:DecodeAuthorizationMessage.
+type AuthorizationFailure struct {
+	ExplicitDeny bool `json:"explicitDeny"`
+	Context      struct {
+		Principal struct {
+			Arn string `json:"arn"`
+		} `json:"principal"`
+		Action   string `json:"action"`
+		Resource string `json:"resource"`
+	} `json:"context"`
+}
+
+// NewAWSError wraps an error returned by an AWS API operation in an AWSError with a hint for fixing it.
+// Errors that aren't AWS API errors are returned as they are.
+func NewAWSError(operation string, err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	return &AWSError{Operation: operation, Code: apiErr.ErrorCode(), Hint: errorHints[apiErr.ErrorCode()], err: err}
+}
+
+func (e *AWSError) Error() string {
+	msg := e.err.Error()
+	if e.Authorization != nil {
+		// The encoded message is long and meaningless once decoded.
+		if i := strings.Index(msg, encodedMessagePrefix); i >= 0 {
+			msg = strings.TrimSpace(msg[:i])
+		}
+		deny := "is not allowed"
+		if e.Authorization.ExplicitDeny {
+			deny = "is explicitly denied by a policy (e.g. a permissions boundary or service control policy)"
+		}
+		msg += fmt.Sprintf("\n%s %s %s on %s", e.Authorization.Context.Principal.Arn, deny, e.Authorization.Context.Action, e.Authorization.Context.Resource)
+	}
+	if e.Hint != "" {
+		msg += "\nHint: " + e.Hint
+	}
+	return msg
+}
+
+func (e *AWSError) Unwrap() error {
+	return e.err
+}
+
+// DecodeAuthorizationError decodes the encoded authorization message of an UnauthorizedOperation error in err's
+// chain with sts:DecodeAuthorizationMessage, and returns err with the encoded message replaced by the action and
+// resource that were denied. If the message can't be decoded, e.g. because the caller isn't allowed
+// sts:DecodeAuthorizationMessage, a warning is logged and err is returned as it is.
+func DecodeAuthorizationError(ctx context.Context, logger Logger, stsClient STSAPI, err error) error {
+	var awsErr *AWSError
+	if !errors.As(err, &awsErr) || awsErr.Code != "UnauthorizedOperation" || awsErr.Authorization != nil {
+		return err
+	}
+	msg := awsErr.err.Error()
+	i := strings.Index(msg, encodedMessagePrefix)
+	if i < 0 {
+		return err
+	}
+	encoded := strings.TrimSpace(msg[i+len(encodedMessagePrefix):])
+
+	resp, derr := stsClient.DecodeAuthorizationMessage(ctx, &sts.DecodeAuthorizationMessageInput{EncodedMessage: aws.String(encoded)})
+	if derr != nil {
+		logger.Warningf("Could not decode authorization failure message: %v", derr)
+		return err
+	}
+	var failure AuthorizationFailure
+	if derr := json.Unmarshal([]byte(aws.ToString(resp.DecodedMessage)), &failure); derr != nil {
+		logger.Warningf("Could not parse decoded authorization failure message: %v", derr)
+		return err
+	}
+
+	// Errors wrapping the AWSError have already formatted its message into their own.
+	encodedMsg := awsErr.Error()
+	awsErr.Authorization = &failure
+	return &rewrittenError{msg: strings.Replace(err.Error(), encodedMsg, awsErr.Error(), 1), err: err}
+}
+
+// rewrittenError replaces the message of an error, keeping the error in its chain.
+type rewrittenError struct {
+	msg string
+	err error
+}
+
+func (e *rewrittenError) Error() string {
+	return e.msg
+}
+
+func (e *rewrittenError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/sethvargo/go-githubactions"
+)
+
+func TestNewAWSError(t *testing.T) {
+	err := NewAWSError("ec2:RunInstances", &smithy.GenericAPIError{Code: "InvalidAMIID.NotFound", Message: "The image id '[ami-12345678]' does not exist"})
+
+	var awsErr *AWSError
+	if !errors.As(err, &awsErr) || awsErr.Operation != "ec2:RunInstances" {
+		t.Fatalf("expected AWSError for ec2:RunInstances, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "does not exist\nHint: Check ec2-image-id") {
+		t.Fatalf("expected message followed by hint, got %q", err.Error())
+	}
+	if !isErrorCode(fmt.Errorf("error starting EC2 instance: %w", err), "InvalidAMIID.NotFound") {
+		t.Fatalf("expected error code to be preserved")
+	}
+
+	plain := errors.New("connection reset")
+	if NewAWSError("ec2:RunInstances", plain) != plain {
+		t.Fatalf("expected non-API error to be returned unchanged")
+	}
+}
+
+func TestDecodeAuthorizationError(t *testing.T) {
+	action := githubactions.New()
+	mockSTS := &MockSTSClient{decodedMessage: `{"allowed":false,"explicitDeny":false,"context":{"principal":{"id":"AROA:1234","arn":"arn:aws:sts::123456789012:assumed-role/runner/1234"},"action":"ec2:RunInstances","resource":"arn:aws:ec2:us-east-1:123456789012:instance/*"}}`}
+
+	ctx := context.Background()
+
+	apiErr := &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "You are not authorized to perform this operation. Encoded authorization failure message: abc123"}
+	err := fmt.Errorf("error starting EC2 instance: %w", NewAWSError("ec2:RunInstances", apiErr))
+
+	err = DecodeAuthorizationError(ctx, action, mockSTS, err)
+	msg := err.Error()
+	if strings.Contains(msg, "abc123") {
+		t.Fatalf("expected encoded message to be removed, got %q", msg)
+	}
+	if !strings.Contains(msg, "assumed-role/runner/1234 is not allowed ec2:RunInstances on arn:aws:ec2:us-east-1:123456789012:instance/*") {
+		t.Fatalf("expected denied action and resource, got %q", msg)
+	}
+	if !strings.Contains(msg, "Hint: The credentials are missing an IAM permission") {
+		t.Fatalf("expected hint, got %q", msg)
+	}
+	if !isErrorCode(err, "UnauthorizedOperation") {
+		t.Fatalf("expected error code to be preserved")
+	}
+}
+
+func TestDecodeAuthorizationErrorNotAllowed(t *testing.T) {
+	action := githubactions.New()
+	mockSTS := &MockSTSClient{}
+
+	ctx := context.Background()
+
+	apiErr := &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "You are not authorized to perform this operation. Encoded authorization failure message: abc123"}
+	err := NewAWSError("ec2:TerminateInstances", apiErr)
+
+	err = DecodeAuthorizationError(ctx, action, mockSTS, err)
+	if !strings.Contains(err.Error(), "abc123") {
+		t.Fatalf("expected encoded message to be kept when it can't be decoded, got %q", err.Error())
+	}
+}
@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// ec2TrustPolicy allows EC2 instances to assume a role through their instance profile.
+const ec2TrustPolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"ec2.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+
+// ssmManagedInstancePolicy is the AWS managed policy the SSM agent needs, without its partition prefix.
+const ssmManagedInstancePolicy = "iam::aws:policy/AmazonSSMManagedInstanceCore"
+
+// managedByTag marks IAM resources created by this action.
+var managedByTag = iamTypes.Tag{Key: aws.String("managed-by"), Value: aws.String("ec2-github-runner")}
+
+// IAMRoleSpec describes the policies of an IAM role created for the instances.
+type IAMRoleSpec struct {
+	// PolicyArns are managed policies attached in addition to AmazonSSMManagedInstanceCore.
+	PolicyArns []string
+	// InlinePolicy is an optional policy document embedded in the role.
+	InlinePolicy string
+	// PermissionsBoundary is the ARN of an optional policy that sets the role's permissions boundary.
+	PermissionsBoundary string
+}
+
+// GetOrCreateIAMRole ensures the named IAM role exists. If it doesn't, the role is created with a trust policy
+// allowing EC2 to assume it, the AmazonSSMManagedInstanceCore policy and the policies in spec, and is tagged as
+// managed by this action. An existing role is used as it is. It returns whether the role was created.
+func GetOrCreateIAMRole(ctx context.Context, logger Logger, iamClient IAMAPI, iamRoleName string, spec IAMRoleSpec) (bool, error) {
+	if spec.InlinePolicy != "" && !json.Valid([]byte(spec.InlinePolicy)) {
+		return false, fmt.Errorf("inline policy for IAM role %s is not valid JSON", iamRoleName)
+	}
+
+	_, err := iamClient.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(iamRoleName)})
+	if err == nil {
+		logger.Infof("IAM role %s already exists.", iamRoleName)
+		return false, nil
+	}
+	if !isErrorCode(err, "NoSuchEntity") {
+		return false, fmt.Errorf("error getting IAM role %s: %w", iamRoleName, err)
+	}
+
+	createRoleInput := &iam.CreateRoleInput{
+		RoleName:                 aws.String(iamRoleName),
+		AssumeRolePolicyDocument: aws.String(ec2TrustPolicy),
+		Description:              aws.String("EC2 instance role created by ec2-github-runner"),
+		Tags:                     []iamTypes.Tag{managedByTag},
+	}
+	if spec.PermissionsBoundary != "" {
+		createRoleInput.PermissionsBoundary = aws.String(spec.PermissionsBoundary)
+	}
+	createRoleResp, err := iamClient.CreateRole(ctx, createRoleInput)
+	if isErrorCode(err, "EntityAlreadyExists") {
+		// Created by a concurrent job, which also attaches the policies.
+		logger.Infof("IAM role %s already exists.", iamRoleName)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error creating IAM role %s: %w", iamRoleName, err)
+	}
+	logger.Infof("Created IAM role %s", iamRoleName)
+
+	policyArns := append([]string{partitionArn(aws.ToString(createRoleResp.Role.Arn), ssmManagedInstancePolicy)}, spec.PolicyArns...)
+	for _, policyArn := range policyArns {
+		attachPolicyInput := &iam.AttachRolePolicyInput{
+			RoleName:  aws.String(iamRoleName),
+			PolicyArn: aws.String(policyArn),
+		}
+		if _, err := iamClient.AttachRolePolicy(ctx, attachPolicyInput); err != nil {
+			return true, fmt.Errorf("error attaching policy %s to IAM role %s: %w", policyArn, iamRoleName, err)
+		}
+		logger.Infof("Attached policy %s to IAM role %s", policyArn, iamRoleName)
+	}
+
+	if spec.InlinePolicy != "" {
+		putPolicyInput := &iam.PutRolePolicyInput{
+			RoleName:       aws.String(iamRoleName),
+			PolicyName:     aws.String("ec2-github-runner"),
+			PolicyDocument: aws.String(spec.InlinePolicy),
+		}
+		if _, err := iamClient.PutRolePolicy(ctx, putPolicyInput); err != nil {
+			return true, fmt.Errorf("error adding inline policy to IAM role %s: %w", iamRoleName, err)
+		}
+		logger.Infof("Added inline policy to IAM role %s", iamRoleName)
+	}
+
+	return true, nil
+}
+
+// partitionArn returns an ARN for resource ("service:region:account:resource") in the same partition as arn,
+// e.g. "aws-cn" for roles in China, defaulting to the "aws" partition.
+func partitionArn(arn, resource string) string {
+	partition := "aws"
+	if parts := strings.SplitN(arn, ":", 3); len(parts) == 3 && parts[0] == "arn" && parts[1] != "" {
+		partition = parts[1]
+	}
+	return "arn:" + partition + ":" + resource
+}
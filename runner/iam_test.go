@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/sethvargo/go-githubactions"
+)
+
+func TestGetOrCreateIAMRole(t *testing.T) {
+	action := githubactions.New()
+	mockIAM := &MockIAMClient{}
+	spec := IAMRoleSpec{
+		PolicyArns:          []string{"arn:aws:iam::123456789012:policy/build-cache"},
+		InlinePolicy:        `{"Version":"2012-10-17","Statement":[]}`,
+		PermissionsBoundary: "arn:aws:iam::123456789012:policy/boundary",
+	}
+
+	ctx := context.Background()
+
+	created, err := GetOrCreateIAMRole(ctx, action, mockIAM, "runner-role", spec)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !created || len(mockIAM.createdRoles) != 1 {
+		t.Fatalf("expected role to be created")
+	}
+
+	role := mockIAM.createdRoles[0]
+	if *role.PermissionsBoundary != spec.PermissionsBoundary {
+		t.Fatalf("expected permissions boundary %s, got %s", spec.PermissionsBoundary, *role.PermissionsBoundary)
+	}
+	if len(role.Tags) != 1 || *role.Tags[0].Key != "managed-by" {
+		t.Fatalf("expected role to be tagged as managed by the action, got %v", role.Tags)
+	}
+	wantPolicies := []string{"arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore", "arn:aws:iam::123456789012:policy/build-cache"}
+	if !reflect.DeepEqual(mockIAM.attachedPolicies, wantPolicies) {
+		t.Fatalf("expected attached policies %v, got %v", wantPolicies, mockIAM.attachedPolicies)
+	}
+	if len(mockIAM.inlinePolicies) != 1 {
+		t.Fatalf("expected inline policy to be added, got %v", mockIAM.inlinePolicies)
+	}
+}
+
+func TestGetOrCreateIAMRoleExisting(t *testing.T) {
+	action := githubactions.New()
+	mockIAM := &MockIAMClient{roles: []string{"runner-role"}}
+
+	ctx := context.Background()
+
+	created, err := GetOrCreateIAMRole(ctx, action, mockIAM, "runner-role", IAMRoleSpec{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if created || len(mockIAM.attachedPolicies) != 0 {
+		t.Fatalf("expected existing role to be left unchanged")
+	}
+}
+
+func TestGetOrCreateIAMRoleInvalidPolicy(t *testing.T) {
+	action := githubactions.New()
+	mockIAM := &MockIAMClient{}
+
+	ctx := context.Background()
+
+	if _, err := GetOrCreateIAMRole(ctx, action, mockIAM, "runner-role", IAMRoleSpec{InlinePolicy: "{"}); err == nil {
+		t.Fatalf("expected error for invalid inline policy")
+	}
+}
+
+func TestPartitionArn(t *testing.T) {
+	got := partitionArn("arn:aws-cn:iam::123456789012:role/runner", ssmManagedInstancePolicy)
+	if got != "arn:aws-cn:iam::aws:policy/AmazonSSMManagedInstanceCore" {
+		t.Fatalf("expected China partition ARN, got %s", got)
+	}
+}
@@ -0,0 +1,376 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// actionModes are the modes of the Action.
+var actionModes = []string{"start", "command", "wait-command", "copy-to-instance", "copy-from-instance", "stop", "print-iam-policy"}
+
+// Modes that inputs are used in. print-iam-policy uses the inputs of start, since the policy depends on them.
+var (
+	launchModes   = []string{"start", "print-iam-policy"}
+	instanceModes = []string{"command", "wait-command", "copy-to-instance", "copy-from-instance", "stop"}
+	commandModes  = []string{"command", "wait-command", "copy-to-instance", "copy-from-instance"}
+	copyModes     = []string{"copy-to-instance", "copy-from-instance", "print-iam-policy"}
+	summaryModes  = []string{"start", "command", "wait-command", "copy-to-instance", "copy-from-instance", "stop"}
+)
+
+// minCommandMaxWaitSecs is the shortest time waited for a command, which is raised to it if set lower.
+const minCommandMaxWaitSecs = 6
+
+// actionInputs are the inputs of the Action, parsed by parseInputs. Inputs that aren't used in the mode are left
+// at their zero values.
+type actionInputs struct {
+	Mode string
+
+	// Inputs of start.
+	ImageId              string
+	SubnetId             string
+	SecurityGroupId      string
+	IAMRoleName          string
+	CreateIAMRole        bool
+	IAMRole              IAMRoleSpec
+	InstanceType         string
+	UserData             string
+	TagSpecifications    []ec2Types.TagSpecification
+	WaitFor              []string
+	InstanceMaxWaitSecs  int
+	Readiness            []ReadinessCondition
+	DiagnosticsDirectory string
+	TerminateOnCancel    bool
+	SkipPreflight        bool
+	Regions              []string
+
+	// Inputs of the modes that act on an instance.
+	InstanceId          string
+	Command             string
+	CommandMaxWaitSecs  int
+	SSMAgentMaxWaitSecs int
+	CommandAsync        bool
+	ParseOutputs        bool
+	CommandId           string
+	LocalPaths          []string
+	RemoteDirectory     string
+	RemotePaths         []string
+	OptionalRemotePaths []string
+	LocalDirectory      string
+	S3Bucket            string
+	S3KeyPrefix         string
+
+	// Inputs of print-iam-policy.
+	PolicyModes     []string
+	PolicyAccountId string
+
+	// Inputs of every mode.
+	AWS                 awsInputs
+	DecodeAuthorization bool
+	DryRun              bool
+	JobSummary          bool
+	HourlyPrice         float64
+}
+
+// awsInputs are the inputs that configure the AWS clients. They can't be set by a profile, since they are needed
+// to read a profile config from S3 or SSM.
+type awsInputs struct {
+	Region                   string
+	RoleToAssume             string
+	RoleDurationSecs         int
+	Audience                 string
+	AssumeRoleArns           []string
+	AssumeRoleExternalId     string
+	AssumeRoleDurationSecs   int
+	AssumeRoleSessionTagging bool
+	Endpoints                Endpoints
+	S3UsePathStyle           bool
+	RetryPolicy              RetryPolicy
+}
+
+// inputField describes an input of the Action: the modes it is used and required in, its default and how it is
+// parsed.
+type inputField struct {
+	name string
+	// modes are the modes the input is used in, or every mode if empty.
+	modes []string
+	// required are the modes the input must be set in.
+	required []string
+	// def is the value of the input when it isn't set, which must match its default in action.yml, if any.
+	def string
+	// set parses a value of the input into the field it sets.
+	set func(v string) error
+}
+
+// fields returns the inputs that configure the AWS clients.
+func (in *awsInputs) fields() []inputField {
+	fields := []inputField{
+		{name: "aws-region", set: stringField(&in.Region)},
+		{name: "role-to-assume", set: stringField(&in.RoleToAssume)},
+		{name: "role-duration-secs", def: "3600", set: intField(&in.RoleDurationSecs, 1)},
+		{name: "audience", def: "sts.amazonaws.com", set: stringField(&in.Audience)},
+		{name: "assume-role-arn", set: listField(&in.AssumeRoleArns)},
+		{name: "assume-role-external-id", set: stringField(&in.AssumeRoleExternalId)},
+		{name: "assume-role-duration-secs", def: "3600", set: intField(&in.AssumeRoleDurationSecs, 1)},
+		{name: "assume-role-session-tagging", def: "false", set: boolField(&in.AssumeRoleSessionTagging)},
+		{name: "endpoint-url", set: urlField(&in.Endpoints.URL)},
+		{name: "use-fips-endpoint", def: "false", set: boolField(&in.Endpoints.FIPS)},
+		{name: "use-dualstack-endpoint", def: "false", set: boolField(&in.Endpoints.DualStack)},
+		{name: "s3-use-path-style", def: "false", set: boolField(&in.S3UsePathStyle)},
+		{name: "retry-max-attempts", def: "8", set: intField(&in.RetryPolicy.MaxAttempts, 1)},
+		{name: "retry-base-delay-ms", def: "500", set: durationField(&in.RetryPolicy.BaseDelay, time.Millisecond)},
+		{name: "retry-max-delay-secs", def: "20", set: durationField(&in.RetryPolicy.MaxDelay, time.Second)},
+	}
+	for _, service := range endpointServices {
+		fields = append(fields, inputField{name: service + "-endpoint-url", set: func(v string) error {
+			var url string
+			if err := urlField(&url)(v); err != nil {
+				return err
+			}
+			if in.Endpoints.Services == nil {
+				in.Endpoints.Services = map[string]string{}
+			}
+			in.Endpoints.Services[service] = url
+			return nil
+		}})
+	}
+	return fields
+}
+
+// fields returns the inputs of the Action, other than mode, profile and profile-config.
+func (in *actionInputs) fields() []inputField {
+	fields := []inputField{
+		{name: "ec2-image-id", modes: launchModes, set: stringField(&in.ImageId)},
+		{name: "subnet-id", modes: launchModes, set: stringField(&in.SubnetId)},
+		{name: "security-group-id", modes: launchModes, set: stringField(&in.SecurityGroupId)},
+		{name: "iam-role-name", modes: launchModes, set: stringField(&in.IAMRoleName)},
+		{name: "create-iam-role", modes: launchModes, def: "false", set: boolField(&in.CreateIAMRole)},
+		{name: "iam-role-policy-arns", modes: launchModes, set: listField(&in.IAMRole.PolicyArns)},
+		{name: "iam-role-inline-policy", modes: launchModes, set: stringField(&in.IAMRole.InlinePolicy)},
+		{name: "iam-role-permissions-boundary", modes: launchModes, set: stringField(&in.IAMRole.PermissionsBoundary)},
+		{name: "ec2-instance-type", modes: launchModes, def: defaultInstanceType, set: stringField(&in.InstanceType)},
+		{name: "user-data", modes: launchModes, set: stringField(&in.UserData)},
+		{name: "tag-specifications", modes: launchModes, set: func(v string) (err error) {
+			in.TagSpecifications, err = ParseTagSpecifications(v)
+			return err
+		}},
+		{name: "wait-for", modes: launchModes, def: ReadyRunning, set: listField(&in.WaitFor)},
+		{name: "instance-max-wait-secs", modes: launchModes, def: "300", set: intField(&in.InstanceMaxWaitSecs, 1)},
+		{name: "diagnostics-directory", modes: []string{"start"}, def: "ec2-diagnostics", set: stringField(&in.DiagnosticsDirectory)},
+		{name: "terminate-on-cancel", modes: launchModes, def: "false", set: boolField(&in.TerminateOnCancel)},
+		{name: "skip-preflight", modes: launchModes, def: "false", set: boolField(&in.SkipPreflight)},
+		{name: "regions", modes: launchModes, set: listField(&in.Regions)},
+
+		{name: "ec2-instance-id", modes: instanceModes, required: instanceModes, set: stringField(&in.InstanceId)},
+		{name: "command", modes: []string{"command"}, required: []string{"command"}, set: stringField(&in.Command)},
+		{name: "command-max-wait-secs", modes: commandModes, def: "300", set: intField(&in.CommandMaxWaitSecs, 1)},
+		{name: "ssm-agent-max-wait-secs", modes: []string{"command", "copy-to-instance", "copy-from-instance"}, def: "60", set: intField(&in.SSMAgentMaxWaitSecs, 1)},
+		{name: "command-async", modes: []string{"command"}, def: "false", set: boolField(&in.CommandAsync)},
+		{name: "command-parse-outputs", modes: []string{"command", "wait-command"}, def: "false", set: boolField(&in.ParseOutputs)},
+		{name: "command-id", modes: []string{"wait-command"}, required: []string{"wait-command"}, set: stringField(&in.CommandId)},
+		{name: "local-paths", modes: []string{"copy-to-instance"}, required: []string{"copy-to-instance"}, set: listField(&in.LocalPaths)},
+		{name: "remote-directory", modes: []string{"copy-to-instance"}, required: []string{"copy-to-instance"}, set: stringField(&in.RemoteDirectory)},
+		{name: "remote-paths", modes: []string{"copy-from-instance"}, set: listField(&in.RemotePaths)},
+		{name: "optional-remote-paths", modes: []string{"copy-from-instance"}, set: listField(&in.OptionalRemotePaths)},
+		{name: "local-directory", modes: []string{"copy-from-instance"}, def: ".", set: stringField(&in.LocalDirectory)},
+		{name: "s3-bucket", modes: copyModes, required: []string{"copy-to-instance", "copy-from-instance"}, set: stringField(&in.S3Bucket)},
+		{name: "s3-key-prefix", modes: copyModes, def: "ec2-github-runner", set: stringField(&in.S3KeyPrefix)},
+
+		{name: "policy-modes", modes: []string{"print-iam-policy"}, set: listField(&in.PolicyModes)},
+		{name: "policy-account-id", modes: []string{"print-iam-policy"}, set: stringField(&in.PolicyAccountId)},
+
+		{name: "decode-authorization-messages", def: "true", set: boolField(&in.DecodeAuthorization)},
+		{name: "dry-run", def: "false", set: boolField(&in.DryRun)},
+		{name: "job-summary", modes: summaryModes, def: "true", set: boolField(&in.JobSummary)},
+		{name: "hourly-price", modes: []string{"start", "stop"}, set: priceField(&in.HourlyPrice)},
+	}
+	return append(in.AWS.fields(), fields...)
+}
+
+// parseInputs parses the inputs of the Action used in its mode, applying their defaults. Every input that is
+// missing or invalid is reported in one error.
+func parseInputs(action *githubactions.Action) (*actionInputs, error) {
+	in := &actionInputs{Mode: action.GetInput("mode"), AWS: awsInputs{RetryPolicy: DefaultRetryPolicy}}
+	if in.Mode == "" {
+		return nil, fmt.Errorf("Required input 'mode' is missing.")
+	}
+	if !slices.Contains(actionModes, in.Mode) {
+		return nil, fmt.Errorf("Unsupported mode: %s. Supported modes are 'start', 'command', 'wait-command', 'copy-to-instance', 'copy-from-instance', 'stop', and 'print-iam-policy'.", in.Mode)
+	}
+
+	problems := parseFields(in.fields(), in.Mode, action.GetInput)
+	problems = append(problems, in.validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid inputs for %s mode: %s", in.Mode, listProblems(problems))
+	}
+	if slices.Contains(commandModes, in.Mode) && in.CommandMaxWaitSecs < minCommandMaxWaitSecs {
+		action.Warningf("command-max-wait-secs raised to minimum %d seconds", minCommandMaxWaitSecs)
+		in.CommandMaxWaitSecs = minCommandMaxWaitSecs
+	}
+	return in, nil
+}
+
+// parseAWSInputs parses the inputs that configure the AWS clients. The inputs that are valid are returned even if
+// others aren't.
+func parseAWSInputs(action *githubactions.Action) (*awsInputs, error) {
+	in := &awsInputs{RetryPolicy: DefaultRetryPolicy}
+	if problems := parseFields(in.fields(), "", action.GetInput); len(problems) > 0 {
+		return in, fmt.Errorf("Invalid inputs: %s", listProblems(problems))
+	}
+	return in, nil
+}
+
+// parseFields parses the inputs of fields used in mode with getInput, returning a problem for each one that is
+// missing or invalid.
+func parseFields(fields []inputField, mode string, getInput func(string) string) []string {
+	var problems []string
+	for _, field := range fields {
+		if len(field.modes) > 0 && !slices.Contains(field.modes, mode) {
+			continue
+		}
+		v := getInput(field.name)
+		if v == "" {
+			if slices.Contains(field.required, mode) {
+				problems = append(problems, fmt.Sprintf("'%s' is required", field.name))
+				continue
+			}
+			if v = field.def; v == "" {
+				continue
+			}
+		}
+		if err := field.set(v); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid value for '%s': %v", field.name, err))
+		}
+	}
+	return problems
+}
+
+// validate checks the inputs that depend on each other, returning the problems found.
+func (in *actionInputs) validate() []string {
+	var problems []string
+	if slices.Contains(launchModes, in.Mode) {
+		if in.Mode == "start" && len(in.Regions) == 0 {
+			var missing []string
+			for name, v := range map[string]string{"ec2-image-id": in.ImageId, "subnet-id": in.SubnetId, "security-group-id": in.SecurityGroupId} {
+				if v == "" {
+					missing = append(missing, fmt.Sprintf("'%s'", name))
+				}
+			}
+			if len(missing) > 0 {
+				slices.Sort(missing)
+				problems = append(problems, fmt.Sprintf("%s required unless 'regions' is set", strings.Join(missing, ", ")))
+			}
+		}
+		if in.CreateIAMRole && in.IAMRoleName == "" {
+			problems = append(problems, "'iam-role-name' is required when 'create-iam-role' is true")
+		}
+		var err error
+		if in.Readiness, err = ParseReadinessConditions(in.WaitFor, in.InstanceMaxWaitSecs); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid value for 'wait-for': %v", err))
+		}
+	}
+	if in.Mode == "copy-from-instance" {
+		if len(in.RemotePaths) == 0 && len(in.OptionalRemotePaths) == 0 {
+			problems = append(problems, "'remote-paths' or 'optional-remote-paths' is required")
+		}
+		if !filepath.IsLocal(in.LocalDirectory) {
+			problems = append(problems, fmt.Sprintf("'local-directory' must be a path within the workspace, got %s", in.LocalDirectory))
+		}
+	}
+	if in.Mode == "print-iam-policy" {
+		var err error
+		if in.PolicyModes, err = ParsePolicyModes(in.PolicyModes); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid value for 'policy-modes': %v", err))
+		}
+	}
+	return problems
+}
+
+func stringField(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func boolField(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", v)
+		}
+		*p = b
+		return nil
+	}
+}
+
+// intField parses a whole number of at least min.
+func intField(p *int, min int) func(string) error {
+	return func(v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < min {
+			return fmt.Errorf("expected a whole number of at least %d, got %q", min, v)
+		}
+		*p = i
+		return nil
+	}
+}
+
+// durationField parses a positive whole number of units.
+func durationField(p *time.Duration, unit time.Duration) func(string) error {
+	return func(v string) error {
+		var i int
+		if err := intField(&i, 1)(v); err != nil {
+			return err
+		}
+		*p = time.Duration(i) * unit
+		return nil
+	}
+}
+
+// priceField parses a positive amount, e.g. a price in USD.
+func priceField(p *float64) func(string) error {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			return fmt.Errorf("expected a positive amount, got %q", v)
+		}
+		*p = f
+		return nil
+	}
+}
+
+// listField parses the non-empty lines of a multiline input.
+func listField(p *[]string) func(string) error {
+	return func(v string) error {
+		*p = splitLines(v)
+		return nil
+	}
+}
+
+func urlField(p *string) func(string) error {
+	return func(v string) error {
+		if err := validateEndpointURL(v); err != nil {
+			return err
+		}
+		*p = v
+		return nil
+	}
+}
+
+// splitLines returns the non-empty lines of s, trimmed of spaces.
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sethvargo/go-githubactions"
+	"gopkg.in/yaml.v3"
+)
+
+// newInputsTestAction returns an Action with the given inputs.
+func newInputsTestAction(inputs map[string]string) *githubactions.Action {
+	return githubactions.New(githubactions.WithGetenv(func(key string) string {
+		return inputs[strings.ToLower(strings.TrimPrefix(key, "INPUT_"))]
+	}))
+}
+
+func TestParseInputsDefaults(t *testing.T) {
+	in, err := parseInputs(newInputsTestAction(map[string]string{"mode": "start", "ec2-image-id": "ami-1", "subnet-id": "subnet-1", "security-group-id": "sg-1"}))
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if in.InstanceType != "t3.micro" || in.InstanceMaxWaitSecs != 300 || in.DiagnosticsDirectory != "ec2-diagnostics" || !in.DecodeAuthorization {
+		t.Fatalf("expected the defaults, got %+v", in)
+	}
+	if len(in.Readiness) != 1 || in.Readiness[0].Name != ReadyRunning {
+		t.Fatalf("expected to wait for running, got %+v", in.Readiness)
+	}
+	if in.AWS.RetryPolicy.MaxAttempts != 8 || in.AWS.RoleDurationSecs != 3600 {
+		t.Fatalf("expected the default retry policy and role duration, got %+v", in)
+	}
+	if in.AWS.AssumeRoleSessionTagging {
+		t.Fatalf("expected session tagging to be opt-in, got %+v", in.AWS)
+	}
+}
+
+func TestParseInputsProblems(t *testing.T) {
+	_, err := parseInputs(newInputsTestAction(map[string]string{"mode": "command", "retry-max-attempts": "many", "use-fips-endpoint": "yes please"}))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, want := range []string{"4 problem(s)", "'ec2-instance-id' is required", "'command' is required", "invalid value for 'retry-max-attempts'", "invalid value for 'use-fips-endpoint'"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in the error, got %s", want, err)
+		}
+	}
+
+	_, err = parseInputs(newInputsTestAction(map[string]string{"mode": "start", "subnet-id": "subnet-1", "create-iam-role": "true"}))
+	if err == nil || !strings.Contains(err.Error(), "'ec2-image-id', 'security-group-id' required unless 'regions' is set") || !strings.Contains(err.Error(), "'iam-role-name' is required") {
+		t.Fatalf("expected the missing start inputs, got %v", err)
+	}
+
+	if _, err := parseInputs(newInputsTestAction(map[string]string{})); err == nil || !strings.Contains(err.Error(), "'mode'") {
+		t.Fatalf("expected mode to be required, got %v", err)
+	}
+}
+
+func TestParseInputsUnusedInputs(t *testing.T) {
+	in, err := parseInputs(newInputsTestAction(map[string]string{"mode": "stop", "ec2-instance-id": "i-1", "command-max-wait-secs": "forever", "ec2-instance-type": ""}))
+	if err != nil {
+		t.Fatalf("expected inputs of other modes to be ignored, got %s", err)
+	}
+	if in.InstanceId != "i-1" || in.CommandMaxWaitSecs != 0 || in.InstanceType != "" {
+		t.Fatalf("expected only the inputs of stop, got %+v", in)
+	}
+
+	in, err = parseInputs(newInputsTestAction(map[string]string{"mode": "command", "ec2-instance-id": "i-1", "command": "true", "command-max-wait-secs": "2"}))
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if in.CommandMaxWaitSecs != minCommandMaxWaitSecs {
+		t.Fatalf("expected command-max-wait-secs raised to %d, got %d", minCommandMaxWaitSecs, in.CommandMaxWaitSecs)
+	}
+}
+
+// TestInputFieldsMatchActionYAML checks that every input in action.yml is parsed, with the same default.
+func TestInputFieldsMatchActionYAML(t *testing.T) {
+	data, err := os.ReadFile("../action.yml")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	var metadata struct {
+		Inputs map[string]struct {
+			Default *string `yaml:"default"`
+		} `yaml:"inputs"`
+	}
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	fields := map[string]inputField{}
+	for _, field := range (&actionInputs{}).fields() {
+		fields[field.name] = field
+	}
+	for name, input := range metadata.Inputs {
+		if slices.Contains([]string{"mode", "profile", "profile-config"}, name) {
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			t.Fatalf("expected input %s of action.yml to be parsed", name)
+		}
+		if input.Default != nil && *input.Default != field.def {
+			t.Fatalf("expected the default of %s to be %q as in action.yml, got %q", name, *input.Default, field.def)
+		}
+		delete(fields, name)
+	}
+	if len(fields) > 0 {
+		t.Fatalf("expected every parsed input to be in action.yml, got extra %v", sortedKeys(fields))
+	}
+}
@@ -0,0 +1,230 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-githubactions"
+)
+
+// Logger reports progress. *githubactions.Action implements it with workflow commands, so messages are annotated
+// and grouped in the workflow log. NewLogger returns implementations for other CI systems.
+type Logger interface {
+	Debugf(msg string, args ...any)
+	Infof(msg string, args ...any)
+	Warningf(msg string, args ...any)
+	Errorf(msg string, args ...any)
+	// Group starts a collapsible group of messages, which EndGroup ends.
+	Group(title string)
+	EndGroup()
+	// AddMask hides secret wherever it appears in later messages.
+	AddMask(secret string)
+}
+
+// Reporter records the results of a step: its outputs and its summary. *githubactions.Action implements it with
+// the files the runner names in GITHUB_OUTPUT and GITHUB_STEP_SUMMARY, which Getenv returns.
+type Reporter interface {
+	IssueFileCommand(cmd *githubactions.Command)
+	AddStepSummary(markdown string)
+	Getenv(key string) string
+}
+
+// Log formats supported by NewLogger.
+const (
+	LogFormatGitHub = "github"
+	LogFormatGitLab = "gitlab"
+	LogFormatJSON   = "json"
+	LogFormatText   = "text"
+)
+
+// logFormats lists the log formats, for error messages.
+var logFormats = []string{LogFormatGitHub, LogFormatGitLab, LogFormatJSON, LogFormatText}
+
+// DetectLogFormat returns the log format of the CI system the process is running in, from the variables the CI
+// system sets, or text if it isn't one that is recognised.
+func DetectLogFormat(getenv func(string) string) string {
+	switch {
+	case getenv("GITHUB_ACTIONS") == "true":
+		return LogFormatGitHub
+	case getenv("GITLAB_CI") == "true":
+		return LogFormatGitLab
+	}
+	return LogFormatText
+}
+
+// NewLogger returns a Logger that writes to w in format, which is one of the LogFormat constants. Debug messages
+// are only written if debug is set, except by the GitHub logger, where the runner decides whether to show them.
+func NewLogger(format string, w io.Writer, debug bool) (Logger, error) {
+	switch format {
+	case LogFormatGitHub:
+		return githubactions.New(githubactions.WithWriter(w)), nil
+	case LogFormatGitLab:
+		return &gitLabLogger{w: w, debug: debug, now: time.Now}, nil
+	case LogFormatJSON:
+		return &jsonLogger{enc: json.NewEncoder(w), debug: debug, now: time.Now}, nil
+	case LogFormatText:
+		return &textLogger{w: w, debug: debug}, nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, supported formats are %s", format, strings.Join(logFormats, ", "))
+}
+
+// masker replaces secrets in messages with ***, for the loggers of CI systems that don't mask them.
+type masker struct {
+	secrets []string
+}
+
+func (m *masker) AddMask(secret string) {
+	if secret != "" {
+		m.secrets = append(m.secrets, secret)
+	}
+}
+
+func (m *masker) mask(s string) string {
+	for _, secret := range m.secrets {
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+	return s
+}
+
+// textLogger writes plain lines, with warnings and errors prefixed by their level and the title of each group.
+type textLogger struct {
+	masker
+	w     io.Writer
+	debug bool
+}
+
+func (l *textLogger) Debugf(msg string, args ...any) {
+	if l.debug {
+		l.printf("DEBUG: ", msg, args...)
+	}
+}
+
+func (l *textLogger) Infof(msg string, args ...any)    { l.printf("", msg, args...) }
+func (l *textLogger) Warningf(msg string, args ...any) { l.printf("WARNING: ", msg, args...) }
+func (l *textLogger) Errorf(msg string, args ...any)   { l.printf("ERROR: ", msg, args...) }
+func (l *textLogger) Group(title string)               { l.printf("==> ", "%s", title) }
+func (l *textLogger) EndGroup()                        {}
+
+func (l *textLogger) printf(prefix, msg string, args ...any) {
+	fmt.Fprintln(l.w, prefix+l.mask(fmt.Sprintf(msg, args...)))
+}
+
+// ANSI escape codes for colouring GitLab CI job log lines.
+const (
+	ansiRed    = "\x1b[0;31m"
+	ansiYellow = "\x1b[0;33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// gitLabLogger writes lines for the GitLab CI job log, with groups as collapsible sections and warnings and
+// errors coloured.
+type gitLabLogger struct {
+	masker
+	w     io.Writer
+	debug bool
+	now   func() time.Time
+	// sections are the names of the open sections, innermost last, and count is the number started so far, which
+	// makes their names unique.
+	sections []string
+	count    int
+}
+
+func (l *gitLabLogger) Debugf(msg string, args ...any) {
+	if l.debug {
+		l.printf("DEBUG: ", "", msg, args...)
+	}
+}
+
+func (l *gitLabLogger) Infof(msg string, args ...any) {
+	l.printf("", "", msg, args...)
+}
+
+func (l *gitLabLogger) Warningf(msg string, args ...any) {
+	l.printf("WARNING: ", ansiYellow, msg, args...)
+}
+
+func (l *gitLabLogger) Errorf(msg string, args ...any) {
+	l.printf("ERROR: ", ansiRed, msg, args...)
+}
+
+func (l *gitLabLogger) Group(title string) {
+	l.count++
+	name := fmt.Sprintf("ec2_runner_%d", l.count)
+	l.sections = append(l.sections, name)
+	fmt.Fprintf(l.w, "\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", l.now().Unix(), name, l.mask(title))
+}
+
+func (l *gitLabLogger) EndGroup() {
+	if len(l.sections) == 0 {
+		return
+	}
+	name := l.sections[len(l.sections)-1]
+	l.sections = l.sections[:len(l.sections)-1]
+	fmt.Fprintf(l.w, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", l.now().Unix(), name)
+}
+
+func (l *gitLabLogger) printf(prefix, colour, msg string, args ...any) {
+	line := prefix + l.mask(fmt.Sprintf(msg, args...))
+	if colour != "" {
+		line = colour + line + ansiReset
+	}
+	fmt.Fprintln(l.w, line)
+}
+
+// jsonLogEntry is a line written by jsonLogger.
+type jsonLogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	// Group is the title of the innermost group the message is in.
+	Group string `json:"group,omitempty"`
+}
+
+// jsonLogger writes each message as a line of JSON, for log collectors.
+type jsonLogger struct {
+	masker
+	enc    *json.Encoder
+	debug  bool
+	now    func() time.Time
+	groups []string
+}
+
+func (l *jsonLogger) Debugf(msg string, args ...any) {
+	if l.debug {
+		l.log("debug", msg, args...)
+	}
+}
+
+func (l *jsonLogger) Infof(msg string, args ...any)    { l.log("info", msg, args...) }
+func (l *jsonLogger) Warningf(msg string, args ...any) { l.log("warning", msg, args...) }
+func (l *jsonLogger) Errorf(msg string, args ...any)   { l.log("error", msg, args...) }
+func (l *jsonLogger) Group(title string)               { l.groups = append(l.groups, l.mask(title)) }
+
+func (l *jsonLogger) EndGroup() {
+	if len(l.groups) > 0 {
+		l.groups = l.groups[:len(l.groups)-1]
+	}
+}
+
+func (l *jsonLogger) log(level, msg string, args ...any) {
+	entry := jsonLogEntry{Time: l.now().UTC(), Level: level, Message: l.mask(fmt.Sprintf(msg, args...))}
+	if len(l.groups) > 0 {
+		entry.Group = l.groups[len(l.groups)-1]
+	}
+	// Encoding a string and a time can't fail, and a failed write to the log can't be reported anywhere.
+	_ = l.enc.Encode(entry)
+}
+
+// nopLogger discards everything logged.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(msg string, args ...any)   {}
+func (nopLogger) Infof(msg string, args ...any)    {}
+func (nopLogger) Warningf(msg string, args ...any) {}
+func (nopLogger) Errorf(msg string, args ...any)   {}
+func (nopLogger) Group(title string)               {}
+func (nopLogger) EndGroup()                        {}
+func (nopLogger) AddMask(secret string)            {}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectLogFormat(t *testing.T) {
+	for env, expected := range map[string]string{
+		"GITHUB_ACTIONS": LogFormatGitHub,
+		"GITLAB_CI":      LogFormatGitLab,
+		"CI":             LogFormatText,
+	} {
+		getenv := func(key string) string {
+			if key == env {
+				return "true"
+			}
+			return ""
+		}
+		if format := DetectLogFormat(getenv); format != expected {
+			t.Fatalf("expected %s with %s set, got %s", expected, env, format)
+		}
+	}
+}
+
+func TestNewLoggerUnknownFormat(t *testing.T) {
+	if _, err := NewLogger("xml", &bytes.Buffer{}, false); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := NewLogger(LogFormatText, &buf, false)
+
+	logger.AddMask("s3cret")
+	logger.Group("Launching")
+	logger.Debugf("hidden")
+	logger.Infof("token is %s", "s3cret")
+	logger.Warningf("slow")
+	logger.EndGroup()
+
+	expected := "==> Launching\ntoken is ***\nWARNING: slow\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestGitLabLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &gitLabLogger{w: &buf, now: func() time.Time { return time.Unix(1700000000, 0) }}
+
+	logger.Group("Launching")
+	logger.Errorf("failed")
+	logger.EndGroup()
+	logger.EndGroup()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "section_start:1700000000:ec2_runner_1[collapsed=true]") || !strings.HasSuffix(lines[0], "Launching") {
+		t.Fatalf("expected a section start, got %q", lines[0])
+	}
+	if lines[1] != ansiRed+"ERROR: failed"+ansiReset {
+		t.Fatalf("expected a red error, got %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "section_end:1700000000:ec2_runner_1") {
+		t.Fatalf("expected the section to end, got %q", lines[2])
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &jsonLogger{enc: json.NewEncoder(&buf), debug: true, now: func() time.Time { return time.Unix(1700000000, 0) }}
+
+	logger.Group("Launching")
+	logger.Debugf("starting")
+	logger.EndGroup()
+	logger.Warningf("done")
+
+	var entries []jsonLogEntry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry jsonLogEntry
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("expected JSON lines, got %q: %v", buf.String(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[0].Level != "debug" || entries[0].Group != "Launching" || entries[0].Time.Unix() != 1700000000 {
+		t.Fatalf("expected a debug entry in the group, got %+v", entries[0])
+	}
+	if entries[1].Level != "warning" || entries[1].Message != "done" || entries[1].Group != "" {
+		t.Fatalf("expected a warning outside the group, got %+v", entries[1])
+	}
+}
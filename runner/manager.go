@@ -0,0 +1,293 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// defaultCommandTimeout is how long RunCommand waits for a command to complete unless CommandSpec.Timeout is set.
+const defaultCommandTimeout = 300 * time.Second
+
+// LaunchSpec describes an EC2 instance to launch.
+type LaunchSpec struct {
+	ImageId         string
+	SubnetId        string
+	SecurityGroupId string
+	// InstanceType defaults to t3.micro in RunnerManager.Start.
+	InstanceType string
+	// IAMRoleName is the role of the instance's profile, which is created if the role doesn't have one.
+	IAMRoleName string
+	// CreateIAMRole creates IAMRoleName as described by IAMRole if it doesn't exist, in RunnerManager.Start.
+	CreateIAMRole bool
+	IAMRole       IAMRoleSpec
+	UserData      string
+	// TagSpecifications are the tags of the instance and its volumes.
+	TagSpecifications []ec2Types.TagSpecification
+	// Readiness are the conditions the instance must meet before it is ready, as returned by
+	// ParseReadinessConditions. It defaults to running in RunnerManager.Start.
+	Readiness []ReadinessCondition
+	// SkipPreflight skips checking the AMI, subnet, security group and instance type before launching in
+	// RunnerManager.Start.
+	SkipPreflight bool
+}
+
+// StartResult describes a launched instance.
+type StartResult struct {
+	InstanceId string
+	// Instance is the instance as described once it was ready, or as launched if it didn't become ready.
+	Instance ec2Types.Instance
+	// LaunchedAt is when RunInstances succeeded, and ReadyAt when the instance met the last readiness condition.
+	LaunchedAt time.Time
+	ReadyAt    time.Time
+	// Phases are how long the instance took to meet each readiness condition, in order.
+	Phases []ReadinessPhase
+}
+
+// CommandSpec describes a shell command to run on an instance.
+type CommandSpec struct {
+	InstanceId string
+	Command    string
+	// Timeout is how long to wait for the command to complete, which defaults to 5 minutes.
+	Timeout time.Duration
+	// SSMAgentTimeout is how long to wait for the instance's SSM agent to be online before sending the command,
+	// which defaults to 60 seconds.
+	SSMAgentTimeout time.Duration
+	// NoWait returns as soon as the command is sent, without waiting for it to complete.
+	NoWait bool
+}
+
+// CommandResult describes a command invocation. Only CommandId is set for a command sent with NoWait.
+type CommandResult struct {
+	InstanceId string
+	CommandId  CommandId
+	Status     ssmTypes.CommandInvocationStatus
+	// ExitCode is the command's exit code, or -1 if it didn't finish.
+	ExitCode   int32
+	Stdout     string
+	Stderr     string
+	SentAt     time.Time
+	FinishedAt time.Time
+}
+
+// StopResult describes a terminated instance.
+type StopResult struct {
+	InstanceId    string
+	PreviousState ec2Types.InstanceStateName
+	CurrentState  ec2Types.InstanceStateName
+	StoppedAt     time.Time
+}
+
+// RunnerManager launches EC2 instances, runs commands on them through SSM and terminates them, for embedding in
+// other tools. It is created by NewRunnerManager with Options.
+type RunnerManager struct {
+	ec2Client EC2API
+	ssmClient SSMAPI
+	iamClient IAMAPI
+	awsConfig *aws.Config
+	// region is the region of the EC2 client, if known, which problems found by the preflight checks name.
+	region      string
+	logger      Logger
+	retryPolicy RetryPolicy
+	clock       Clock
+}
+
+// Option configures a RunnerManager.
+type Option func(*RunnerManager)
+
+// WithEC2Client sets the EC2 client.
+func WithEC2Client(client EC2API) Option {
+	return func(m *RunnerManager) { m.ec2Client = client }
+}
+
+// WithSSMClient sets the SSM client.
+func WithSSMClient(client SSMAPI) Option {
+	return func(m *RunnerManager) { m.ssmClient = client }
+}
+
+// WithIAMClient sets the IAM client, which is needed to launch instances with an IAM role.
+func WithIAMClient(client IAMAPI) Option {
+	return func(m *RunnerManager) { m.iamClient = client }
+}
+
+// WithAWSConfig creates the clients that aren't set with other options from cfg.
+func WithAWSConfig(cfg aws.Config) Option {
+	return func(m *RunnerManager) { m.awsConfig = &cfg }
+}
+
+// WithLogger sets where progress is logged, which is nowhere by default.
+func WithLogger(logger Logger) Option {
+	return func(m *RunnerManager) { m.logger = logger }
+}
+
+// WithRetryPolicy sets how failed AWS API calls are retried, which is DefaultRetryPolicy by default. Retries can
+// be disabled with a MaxAttempts of 1.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(m *RunnerManager) { m.retryPolicy = policy }
+}
+
+// WithClock sets the clock used for waiting and timestamps, which is the real time by default.
+func WithClock(clock Clock) Option {
+	return func(m *RunnerManager) { m.clock = clock }
+}
+
+// NewRunnerManager returns a RunnerManager configured by opts. The EC2 and SSM clients must be set with
+// WithEC2Client and WithSSMClient, or created with WithAWSConfig. The clients' calls are retried by the retry
+// policy, so clients created from an aws.Config should have the SDK's retryer disabled with aws.NopRetryer.
+func NewRunnerManager(opts ...Option) (*RunnerManager, error) {
+	m := &RunnerManager{
+		logger:      nopLogger{},
+		retryPolicy: DefaultRetryPolicy,
+		clock:       systemClock{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if cfg := m.awsConfig; cfg != nil {
+		if m.ec2Client == nil {
+			m.ec2Client = ec2.NewFromConfig(*cfg, func(o *ec2.Options) { o.Retryer = aws.NopRetryer{} })
+		}
+		if m.ssmClient == nil {
+			m.ssmClient = ssm.NewFromConfig(*cfg, func(o *ssm.Options) { o.Retryer = aws.NopRetryer{} })
+		}
+		if m.iamClient == nil {
+			m.iamClient = iam.NewFromConfig(*cfg, func(o *iam.Options) { o.Retryer = aws.NopRetryer{} })
+		}
+	}
+	if m.ec2Client == nil || m.ssmClient == nil {
+		return nil, fmt.Errorf("EC2 and SSM clients are required, set them with WithEC2Client and WithSSMClient or WithAWSConfig")
+	}
+	if m.retryPolicy.MaxAttempts < 1 {
+		return nil, fmt.Errorf("invalid retry policy, MaxAttempts must be at least 1")
+	}
+
+	// An SDK client knows its region, which the retrying wrapper hides.
+	if client, ok := m.ec2Client.(interface{ Options() ec2.Options }); ok {
+		m.region = client.Options().Region
+	}
+	if m.region == "" && m.awsConfig != nil {
+		m.region = m.awsConfig.Region
+	}
+
+	m.ec2Client = NewRetryingEC2Client(m.logger, m.ec2Client, m.retryPolicy)
+	m.ssmClient = NewRetryingSSMClient(m.logger, m.ssmClient, m.retryPolicy)
+	if m.iamClient != nil {
+		m.iamClient = NewRetryingIAMClient(m.logger, m.iamClient, m.retryPolicy)
+	}
+	return m, nil
+}
+
+// Start launches an instance as described by spec and waits for it to be ready. Unless spec.SkipPreflight is set,
+// the launch parameters are checked first, and all the problems found are returned as one error. If the instance
+// was launched but didn't become ready, the result is returned with the error, and the instance is left running.
+func (m *RunnerManager) Start(ctx context.Context, spec LaunchSpec) (*StartResult, error) {
+	ctx = withClock(ctx, m.clock)
+	if spec.InstanceType == "" {
+		spec.InstanceType = defaultInstanceType
+	}
+	if len(spec.Readiness) == 0 {
+		spec.Readiness = []ReadinessCondition{{Name: ReadyRunning, Timeout: defaultReadinessTimeouts[ReadyRunning]}}
+	}
+	if spec.IAMRoleName != "" && m.iamClient == nil {
+		return nil, fmt.Errorf("an IAM client is required to launch an instance with an IAM role")
+	}
+
+	if !spec.SkipPreflight {
+		target := RegionTarget{Region: m.region, ImageId: spec.ImageId, SubnetId: spec.SubnetId, SecurityGroupId: spec.SecurityGroupId}
+		if problems := PreflightStart(ctx, m.logger, m.ec2Client, target, spec.InstanceType); len(problems) > 0 {
+			return nil, fmt.Errorf("preflight checks found %s", listProblems(problems))
+		}
+	}
+	if spec.CreateIAMRole && spec.IAMRoleName != "" {
+		if _, err := GetOrCreateIAMRole(ctx, m.logger, m.iamClient, spec.IAMRoleName, spec.IAMRole); err != nil {
+			return nil, err
+		}
+	}
+	return CreateAndStartEC2Instance(ctx, m.logger, m.ec2Client, m.ssmClient, m.iamClient, spec)
+}
+
+// RunCommand runs a shell command on an instance with the AWS-RunShellScript document, and waits for it to
+// complete unless spec.NoWait is set. If the command fails, the result is returned with an error containing its
+// standard error. If ctx is done while waiting, the command is cancelled.
+func (m *RunnerManager) RunCommand(ctx context.Context, spec CommandSpec) (*CommandResult, error) {
+	ctx = withClock(ctx, m.clock)
+	if spec.Timeout == 0 {
+		spec.Timeout = defaultCommandTimeout
+	}
+	if spec.SSMAgentTimeout == 0 {
+		spec.SSMAgentTimeout = defaultSSMAgentMaxWaitTime * time.Second
+	}
+
+	result := &CommandResult{InstanceId: spec.InstanceId, ExitCode: -1}
+	commandId, err := SendCommandToEC2Instance(ctx, m.logger, m.ssmClient, spec.InstanceId, spec.Command, int(spec.SSMAgentTimeout/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	result.CommandId = commandId
+	result.SentAt = now(ctx)
+	if spec.NoWait {
+		return result, nil
+	}
+	return result, m.waitCommand(ctx, result, spec.Timeout)
+}
+
+// WaitCommand waits up to timeout for a command sent to an instance, e.g. by RunCommand with NoWait, to complete.
+// The timeout defaults to 5 minutes. If the command fails, the result is returned with an error containing its
+// standard error. If ctx is done while waiting, the command is cancelled.
+func (m *RunnerManager) WaitCommand(ctx context.Context, instanceId string, commandId CommandId, timeout time.Duration) (*CommandResult, error) {
+	ctx = withClock(ctx, m.clock)
+	if timeout == 0 {
+		timeout = defaultCommandTimeout
+	}
+
+	result := &CommandResult{InstanceId: instanceId, CommandId: commandId, ExitCode: -1}
+	return result, m.waitCommand(ctx, result, timeout)
+}
+
+// waitCommand waits for the command of result to complete and fills in its status and output.
+func (m *RunnerManager) waitCommand(ctx context.Context, result *CommandResult, timeout time.Duration) error {
+	details, err := WaitForCommand(ctx, m.logger, m.ssmClient, result.InstanceId, result.CommandId, int(timeout/time.Second))
+	if details != nil {
+		result.Status = details.Status
+		result.ExitCode = details.ResponseCode
+		result.Stdout = aws.ToString(details.StandardOutputContent)
+		result.Stderr = aws.ToString(details.StandardErrorContent)
+		result.FinishedAt = now(ctx)
+	}
+	if err != nil && ctx.Err() != nil {
+		cancelOutstandingCommand(m.logger, m.ssmClient, result.InstanceId, result.CommandId)
+	}
+	return err
+}
+
+// Stop terminates an instance.
+func (m *RunnerManager) Stop(ctx context.Context, instanceId string) (*StopResult, error) {
+	ctx = withClock(ctx, m.clock)
+	change, err := TerminateEC2Instance(ctx, m.logger, m.ec2Client, instanceId)
+	if err != nil {
+		return nil, err
+	}
+	result := &StopResult{InstanceId: instanceId, StoppedAt: now(ctx)}
+	if change != nil {
+		if change.PreviousState != nil {
+			result.PreviousState = change.PreviousState.Name
+		}
+		if change.CurrentState != nil {
+			result.CurrentState = change.CurrentState.Name
+		}
+	}
+	return result, nil
+}
+
+// Describe returns the current details of an instance.
+func (m *RunnerManager) Describe(ctx context.Context, instanceId string) (*ec2Types.Instance, error) {
+	return DescribeEC2Instance(withClock(ctx, m.clock), m.ec2Client, instanceId)
+}
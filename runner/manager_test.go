@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// fakeClock is a Clock whose Sleep advances the time without waiting.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func newTestManager(t *testing.T, ec2Client EC2API, ssmClient SSMAPI, opts ...Option) (*RunnerManager, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}
+	opts = append([]Option{WithEC2Client(ec2Client), WithSSMClient(ssmClient), WithIAMClient(&MockIAMClient{}), WithClock(clock)}, opts...)
+	m, err := NewRunnerManager(opts...)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	return m, clock
+}
+
+func TestNewRunnerManagerErrors(t *testing.T) {
+	if _, err := NewRunnerManager(WithEC2Client(&MockEC2Client{})); err == nil {
+		t.Fatalf("expected an error without an SSM client")
+	}
+	if _, err := NewRunnerManager(WithEC2Client(&MockEC2Client{}), WithSSMClient(&MockSSMClient{}), WithRetryPolicy(RetryPolicy{})); err == nil {
+		t.Fatalf("expected an error for a retry policy without attempts")
+	}
+}
+
+func TestRunnerManagerStart(t *testing.T) {
+	mockEC2 := &InstanceStatesEC2Client{states: []ec2Types.InstanceStateName{ec2Types.InstanceStateNamePending, ec2Types.InstanceStateNamePending, ec2Types.InstanceStateNameRunning}}
+	m, clock := newTestManager(t, mockEC2, &MockSSMClient{})
+	launchedAt := clock.now
+
+	result, err := m.Start(context.Background(), LaunchSpec{ImageId: "ami-12345678", SubnetId: "subnet-12345678", SecurityGroupId: "sg-12345678"})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if result.InstanceId != testEC2ClientId || result.Instance.State == nil || result.Instance.State.Name != ec2Types.InstanceStateNameRunning {
+		t.Fatalf("expected a running instance, got %+v", result)
+	}
+	if len(result.Phases) != 1 || result.Phases[0].Condition != ReadyRunning || result.Phases[0].Duration != 10*time.Second {
+		t.Fatalf("expected running after 10s, got %+v", result.Phases)
+	}
+	if !result.LaunchedAt.Equal(launchedAt) || result.ReadyAt.Sub(result.LaunchedAt) != 10*time.Second {
+		t.Fatalf("expected the launch and ready times from the clock, got %s and %s", result.LaunchedAt, result.ReadyAt)
+	}
+}
+
+func TestRunnerManagerStartPreflight(t *testing.T) {
+	m, _ := newTestManager(t, &PreflightEC2Client{imageMissing: true}, &MockSSMClient{}, WithAWSConfig(aws.Config{Region: "eu-west-1"}))
+
+	_, err := m.Start(context.Background(), LaunchSpec{ImageId: "ami-12345678", SubnetId: "subnet-12345678", SecurityGroupId: "sg-12345678"})
+	if err == nil || !strings.Contains(err.Error(), "preflight checks found") {
+		t.Fatalf("expected a preflight error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "AMI ami-12345678 in region eu-west-1") {
+		t.Fatalf("expected the problem to name the region, got %s", err)
+	}
+
+	// The region of an SDK client is used when there is no AWS config.
+	m, _ = newTestManager(t, ec2.New(ec2.Options{Region: "ap-south-1"}), &MockSSMClient{})
+	if m.region != "ap-south-1" {
+		t.Fatalf("expected the region of the EC2 client, got %q", m.region)
+	}
+}
+
+func TestRunnerManagerRunCommand(t *testing.T) {
+	mockSSM := &CommandResultSSMClient{result: commandInvocationOutput(ssmTypes.CommandInvocationStatusSuccess, 0, "hello\n", "")}
+	m, _ := newTestManager(t, &MockEC2Client{}, mockSSM)
+
+	result, err := m.RunCommand(context.Background(), CommandSpec{InstanceId: testEC2ClientId, Command: "echo hello"})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if result.Status != ssmTypes.CommandInvocationStatusSuccess || result.Stdout != "hello\n" || result.CommandId == "" {
+		t.Fatalf("expected a successful invocation, got %+v", result)
+	}
+
+	mockSSM.result = commandInvocationOutput(ssmTypes.CommandInvocationStatusFailed, 2, "", "boom")
+	result, err = m.RunCommand(context.Background(), CommandSpec{InstanceId: testEC2ClientId, Command: "false"})
+	if err == nil || result == nil || result.ExitCode != 2 || result.Stderr != "boom" {
+		t.Fatalf("expected the failed invocation with an error, got %+v, %v", result, err)
+	}
+
+	// The SSM agent of another instance never comes online, so RunCommand gives up after SSMAgentTimeout.
+	m, clock := newTestManager(t, &MockEC2Client{}, &MockSSMClient{})
+	start := clock.now
+	if _, err := m.RunCommand(context.Background(), CommandSpec{InstanceId: "i-other", Command: "true", SSMAgentTimeout: 2 * time.Minute}); err == nil {
+		t.Fatalf("expected an error for an instance whose SSM agent isn't online")
+	}
+	if waited := clock.now.Sub(start); waited != 2*time.Minute {
+		t.Fatalf("expected to wait 2m0s for the SSM agent, waited %s", waited)
+	}
+}
+
+func TestRunnerManagerRunCommandNoWait(t *testing.T) {
+	m, _ := newTestManager(t, &MockEC2Client{}, &MockSSMClient{})
+
+	result, err := m.RunCommand(context.Background(), CommandSpec{InstanceId: testEC2ClientId, Command: "sleep 600", NoWait: true})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if result.CommandId == "" || result.Status != "" || result.ExitCode != -1 {
+		t.Fatalf("expected only the command ID, got %+v", result)
+	}
+}
+
+func TestRunnerManagerWaitCommand(t *testing.T) {
+	mockSSM := &CancelRecordingSSMClient{CommandResultSSMClient: CommandResultSSMClient{result: commandInvocationOutput(ssmTypes.CommandInvocationStatusSuccess, 0, "done\n", "")}}
+	m, _ := newTestManager(t, &MockEC2Client{}, mockSSM)
+
+	result, err := m.WaitCommand(context.Background(), testEC2ClientId, "command-id-123", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if result.CommandId != "command-id-123" || result.Status != ssmTypes.CommandInvocationStatusSuccess || result.Stdout != "done\n" {
+		t.Fatalf("expected the finished invocation, got %+v", result)
+	}
+
+	// A command that is still running when ctx is done is cancelled.
+	mockSSM.result = commandInvocationOutput(ssmTypes.CommandInvocationStatusInProgress, 0, "", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = m.WaitCommand(ctx, testEC2ClientId, "command-id-123", time.Minute)
+	if err == nil || result.Status != "" || !slices.Equal(mockSSM.cancelled, []string{"command-id-123"}) {
+		t.Fatalf("expected the command to be cancelled, got %+v, %v, cancelled %v", result, err, mockSSM.cancelled)
+	}
+}
+
+func TestRunnerManagerStop(t *testing.T) {
+	m, _ := newTestManager(t, &MockEC2Client{}, &MockSSMClient{})
+
+	result, err := m.Stop(context.Background(), testEC2ClientId)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if result.InstanceId != testEC2ClientId || result.CurrentState != ec2Types.InstanceStateNameTerminated {
+		t.Fatalf("expected a terminated instance, got %+v", result)
+	}
+}
@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sethvargo/go-githubactions"
+)
+
+// maxOutputBytes limits the size of a single step output. GitHub allows 1 MiB of outputs per job in total,
+// so larger values are truncated to leave room for the other outputs.
+const maxOutputBytes = 64 * 1024
+
+var (
+	// setOutputLine matches the deprecated "::set-output name=<k>::<v>" workflow command.
+	setOutputLine = regexp.MustCompile(`^::set-output name=([A-Za-z_][A-Za-z0-9_-]*)::(.*)$`)
+	// keyValueLine matches "<k>=<v>" lines.
+	keyValueLine = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_-]*)=(.*)$`)
+)
+
+// commandOutputNames are the outputs set from every command invocation, which parsed outputs may not replace.
+var commandOutputNames = []string{"command-id", "stdout", "stderr", "exit-code"}
+
+// SetCommandOutputs sets the stdout, stderr and exit-code step outputs from the result of a finished command.
+// If parseOutputs is true, stdout lines of the form "::set-output name=k::v" or "k=v" are also set
+// as individual step outputs.
+func SetCommandOutputs(logger Logger, reporter Reporter, result *CommandResult, parseOutputs bool) {
+	stdout := result.Stdout
+
+	setMultilineOutput(logger, reporter, "stdout", stdout)
+	setMultilineOutput(logger, reporter, "stderr", result.Stderr)
+	setMultilineOutput(logger, reporter, "exit-code", strconv.Itoa(int(result.ExitCode)))
+
+	if !parseOutputs {
+		return
+	}
+	outputs, names := parseOutputLines(stdout)
+	for _, name := range names {
+		if isCommandOutputName(name) {
+			logger.Warningf("Ignoring output '%s' parsed from command output: it is reserved", name)
+			continue
+		}
+		setMultilineOutput(logger, reporter, name, outputs[name])
+	}
+}
+
+// parseOutputLines extracts outputs from lines of the form "::set-output name=k::v" or "k=v".
+// It returns the outputs and their names in the order they first appeared; later values replace earlier ones.
+func parseOutputLines(stdout string) (map[string]string, []string) {
+	outputs := map[string]string{}
+	var names []string
+
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		match := setOutputLine.FindStringSubmatch(line)
+		if match == nil {
+			match = keyValueLine.FindStringSubmatch(line)
+		}
+		if match == nil {
+			continue
+		}
+		if _, ok := outputs[match[1]]; !ok {
+			names = append(names, match[1])
+		}
+		outputs[match[1]] = match[2]
+	}
+	return outputs, names
+}
+
+// isCommandOutputName reports whether name is one of the outputs set from every command invocation.
+func isCommandOutputName(name string) bool {
+	for _, n := range commandOutputNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setMultilineOutput sets a step output using a random delimiter that does not occur in the value, so
+// values containing newlines or the delimiter used by githubactions.Action.SetOutput can't inject other outputs.
+// Values longer than maxOutputBytes are truncated, with a warning logged.
+func setMultilineOutput(logger Logger, reporter Reporter, name, value string) {
+	if len(value) > maxOutputBytes {
+		logger.Warningf("Output '%s' truncated from %d to %d bytes", name, len(value), maxOutputBytes)
+		value = truncateUTF8(value, maxOutputBytes)
+	}
+
+	delimiter := outputDelimiter()
+	for strings.Contains(value, delimiter) {
+		delimiter = outputDelimiter()
+	}
+
+	reporter.IssueFileCommand(&githubactions.Command{
+		Name:    "output",
+		Message: fmt.Sprintf("%s<<%s\n%s\n%s", name, delimiter, value, delimiter),
+	})
+}
+
+// outputDelimiter returns a random heredoc delimiter for GITHUB_OUTPUT.
+func outputDelimiter() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "ghadelimiter_" + hex.EncodeToString(b)
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a multi-byte character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sethvargo/go-githubactions"
+)
+
+// readOutputs parses a GITHUB_OUTPUT file written with heredoc delimiters.
+func readOutputs(t *testing.T, path string) map[string]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := regexp.MustCompile(`^([^<]+)<<(.+)$`)
+	outputs := map[string]string{}
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		match := header.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+		var value []string
+		for i++; i < len(lines) && lines[i] != match[2]; i++ {
+			value = append(value, lines[i])
+		}
+		outputs[match[1]] = strings.Join(value, "\n")
+	}
+	return outputs
+}
+
+func newOutputAction(t *testing.T) (*githubactions.Action, string) {
+	path := filepath.Join(t.TempDir(), "output")
+	action := githubactions.New(githubactions.WithGetenv(func(k string) string {
+		if k == "GITHUB_OUTPUT" {
+			return path
+		}
+		return ""
+	}))
+	return action, path
+}
+
+func TestSetCommandOutputs(t *testing.T) {
+	action, path := newOutputAction(t)
+	result := &CommandResult{
+		ExitCode: 2,
+		Stdout:   "line 1\n_GitHubActionsFileCommandDelimeter_\nversion=1.2.3\n::set-output name=artifact::app.tar\nstdout=ignored\nnot an output",
+		Stderr:   "warning: something",
+	}
+
+	var log strings.Builder
+	logger, _ := NewLogger(LogFormatText, &log, false)
+	SetCommandOutputs(logger, action, result, true)
+
+	outputs := readOutputs(t, path)
+	if outputs["stdout"] != result.Stdout {
+		t.Fatalf("expected stdout output %q, got %q", result.Stdout, outputs["stdout"])
+	}
+	if outputs["stderr"] != "warning: something" {
+		t.Fatalf("expected stderr output 'warning: something', got %q", outputs["stderr"])
+	}
+	if outputs["exit-code"] != "2" {
+		t.Fatalf("expected exit-code output 2, got %q", outputs["exit-code"])
+	}
+	if outputs["version"] != "1.2.3" || outputs["artifact"] != "app.tar" {
+		t.Fatalf("expected parsed outputs version=1.2.3 and artifact=app.tar, got %v", outputs)
+	}
+	if len(outputs) != 5 {
+		t.Fatalf("expected 5 outputs, got %v", outputs)
+	}
+	if !strings.Contains(log.String(), "WARNING: Ignoring output 'stdout' parsed from command output: it is reserved") {
+		t.Fatalf("expected a warning logged about the reserved stdout output, got %q", log.String())
+	}
+}
+
+func TestSetCommandOutputsWithoutParsing(t *testing.T) {
+	action, path := newOutputAction(t)
+	SetCommandOutputs(nopLogger{}, action, &CommandResult{Stdout: "version=1.2.3"}, false)
+
+	outputs := readOutputs(t, path)
+	if _, ok := outputs["version"]; ok {
+		t.Fatalf("expected stdout not to be parsed, got %v", outputs)
+	}
+}
+
+func TestSetMultilineOutputTruncates(t *testing.T) {
+	action, path := newOutputAction(t)
+
+	var log strings.Builder
+	logger, _ := NewLogger(LogFormatText, &log, false)
+	setMultilineOutput(logger, action, "stdout", strings.Repeat("é", maxOutputBytes))
+
+	value := readOutputs(t, path)["stdout"]
+	if len(value) > maxOutputBytes || !strings.HasSuffix(value, "é") {
+		t.Fatalf("expected output truncated to %d bytes on a character boundary, got %d bytes", maxOutputBytes, len(value))
+	}
+	if !strings.Contains(log.String(), "WARNING: Output 'stdout' truncated") {
+		t.Fatalf("expected a warning logged about the truncation, got %q", log.String())
+	}
+}
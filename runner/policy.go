@@ -0,0 +1,290 @@
+package runner
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// policyModes are the modes an IAM policy can be printed for.
+var policyModes = []string{"start", "command", "wait-command", "copy-to-instance", "copy-from-instance", "stop"}
+
+// PolicyDocument is an IAM policy document.
+type PolicyDocument struct {
+	Version   string
+	Statement []PolicyStatement
+}
+
+// PolicyStatement is a statement of an IAM policy document.
+type PolicyStatement struct {
+	Sid       string
+	Effect    string
+	Action    []string
+	Resource  []string
+	Condition map[string]map[string]any `json:",omitempty"`
+}
+
+// PolicyOptions are the inputs that determine the permissions the action needs.
+type PolicyOptions struct {
+	// Modes are the modes the policy must allow.
+	Modes []string
+	// Partition and Account are used in resource ARNs; Account may be "*".
+	Partition string
+	Account   string
+	// Targets are the regions, AMIs, subnets and security groups instances are launched with. Resources that
+	// aren't set are matched with wildcards.
+	Targets []RegionTarget
+	// InstanceTags are the tags instances are launched with, which the instance permissions are conditioned on, and
+	// VolumeTags those their volumes are launched with.
+	InstanceTags        map[string]string
+	VolumeTags          map[string]string
+	IAMRoleName         string
+	CreateIAMRole       bool
+	RolePolicyArns      []string
+	PermissionsBoundary string
+	Readiness           []ReadinessCondition
+	TerminateOnCancel   bool
+	SkipPreflight       bool
+	S3Bucket            string
+	S3KeyPrefix         string
+	DecodeAuthorization bool
+	// ProfileConfig is the location a profile is read from, if one is used, and ProfileConfigRegion the region
+	// it is read from if it is an SSM parameter.
+	ProfileConfig       string
+	ProfileConfigRegion string
+}
+
+// BuildIAMPolicy returns the least-privilege IAM policy for the calls the action makes in opts.Modes with the
+// given options. Instance permissions are limited to instances with the launch tags when there are any, commands
+// to the AWS-RunShellScript document, iam:PassRole to the instance role and S3 access to the staging prefix.
+func BuildIAMPolicy(opts PolicyOptions) PolicyDocument {
+	modes := map[string]bool{}
+	for _, mode := range opts.Modes {
+		modes[mode] = true
+	}
+	start := modes["start"]
+	sendsCommands := modes["command"] || modes["copy-to-instance"] || modes["copy-from-instance"]
+	waitsForCommands := sendsCommands || modes["wait-command"]
+	usesSSMAgent := modes["command"] || modes["copy-to-instance"] || modes["copy-from-instance"]
+	copies := modes["copy-to-instance"] || modes["copy-from-instance"]
+	if start {
+		for _, condition := range opts.Readiness {
+			switch condition.Name {
+			case ReadySSMOnline:
+				usesSSMAgent = true
+			case ReadyUserDataComplete:
+				sendsCommands, waitsForCommands, usesSSMAgent = true, true, true
+			}
+		}
+	}
+
+	var regions []string
+	for _, target := range opts.Targets {
+		regions = append(regions, orWildcard(target.Region))
+	}
+	if len(regions) == 0 {
+		regions = []string{"*"}
+	}
+	ec2Arns := func(resource string) []string {
+		var arns []string
+		for _, region := range regions {
+			arns = append(arns, fmt.Sprintf("arn:%s:ec2:%s:%s:%s", opts.Partition, region, opts.Account, resource))
+		}
+		return arns
+	}
+	instanceArns := ec2Arns("instance/*")
+	tagsCondition := func(key string, tags map[string]string) map[string]map[string]any {
+		if len(tags) == 0 {
+			return nil
+		}
+		values := map[string]any{}
+		for tag, value := range tags {
+			values[key+tag] = value
+		}
+		return map[string]map[string]any{"StringEquals": values}
+	}
+	tagCondition := func(key string) map[string]map[string]any {
+		return tagsCondition(key, opts.InstanceTags)
+	}
+
+	var statements []PolicyStatement
+	add := func(sid string, actions, resources []string, condition map[string]map[string]any) {
+		statements = append(statements, PolicyStatement{Sid: sid, Effect: "Allow", Action: actions, Resource: resources, Condition: condition})
+	}
+
+	if start {
+		var launchResources []string
+		for _, target := range opts.Targets {
+			region := orWildcard(target.Region)
+			launchResources = append(launchResources,
+				fmt.Sprintf("arn:%s:ec2:%s::image/%s", opts.Partition, region, orWildcard(target.ImageId)),
+				fmt.Sprintf("arn:%s:ec2:%s:%s:subnet/%s", opts.Partition, region, opts.Account, orWildcard(target.SubnetId)),
+				fmt.Sprintf("arn:%s:ec2:%s:%s:security-group/%s", opts.Partition, region, opts.Account, orWildcard(target.SecurityGroupId)),
+			)
+		}
+		launchResources = append(launchResources, ec2Arns("network-interface/*")...)
+		add("LaunchInstanceResources", []string{"ec2:RunInstances"}, launchResources, nil)
+		// Each resource type is only matched by the tags of its own tag specification.
+		add("LaunchInstances", []string{"ec2:RunInstances"}, instanceArns, tagCondition("aws:RequestTag/"))
+		add("LaunchVolumes", []string{"ec2:RunInstances"}, ec2Arns("volume/*"), tagsCondition("aws:RequestTag/", opts.VolumeTags))
+		var taggedArns []string
+		if len(opts.InstanceTags) > 0 {
+			taggedArns = append(taggedArns, instanceArns...)
+		}
+		if len(opts.VolumeTags) > 0 {
+			taggedArns = append(taggedArns, ec2Arns("volume/*")...)
+		}
+		if len(taggedArns) > 0 {
+			add("TagOnLaunch", []string{"ec2:CreateTags"}, taggedArns, map[string]map[string]any{
+				"StringEquals": {"ec2:CreateAction": "RunInstances"},
+			})
+		}
+
+		describe := []string{"ec2:DescribeInstances"}
+		for _, condition := range opts.Readiness {
+			if condition.Name == ReadyStatusOk {
+				describe = append(describe, "ec2:DescribeInstanceStatus")
+			}
+		}
+		if !opts.SkipPreflight {
+			describe = append(describe, "ec2:DescribeImages", "ec2:DescribeSubnets", "ec2:DescribeSecurityGroups", "ec2:DescribeInstanceTypeOfferings", "ec2:DescribeInstanceTypes")
+		}
+		// EC2 describe actions don't support resource-level permissions.
+		add("DescribeInstances", describe, []string{"*"}, nil)
+		add("InstanceDiagnostics", []string{"ec2:GetConsoleOutput", "ec2:GetConsoleScreenshot"}, instanceArns, tagCondition("aws:ResourceTag/"))
+	}
+	if modes["stop"] && !start {
+		// stop describes the instance for the job summary and to check it exists in a dry run.
+		add("DescribeInstances", []string{"ec2:DescribeInstances"}, []string{"*"}, nil)
+	}
+	if modes["stop"] || (start && opts.TerminateOnCancel) {
+		add("TerminateInstances", []string{"ec2:TerminateInstances"}, instanceArns, tagCondition("aws:ResourceTag/"))
+	}
+
+	if sendsCommands {
+		var documentArns []string
+		for _, region := range regions {
+			documentArns = append(documentArns, fmt.Sprintf("arn:%s:ssm:%s::document/AWS-RunShellScript", opts.Partition, region))
+		}
+		add("SendCommandDocument", []string{"ssm:SendCommand"}, documentArns, nil)
+		add("SendCommandInstances", []string{"ssm:SendCommand"}, instanceArns, tagCondition("ssm:resourceTag/"))
+	}
+	if waitsForCommands {
+		// Command invocations have no ARN of their own.
+		add("CommandInvocations", []string{"ssm:GetCommandInvocation", "ssm:CancelCommand"}, []string{"*"}, nil)
+	}
+	if usesSSMAgent {
+		add("DescribeSSMInstances", []string{"ssm:DescribeInstanceInformation"}, []string{"*"}, nil)
+	}
+
+	if start && opts.IAMRoleName != "" {
+		roleArn := fmt.Sprintf("arn:%s:iam::%s:role/%s", opts.Partition, opts.Account, opts.IAMRoleName)
+		profileArn := fmt.Sprintf("arn:%s:iam::%s:instance-profile/%s", opts.Partition, opts.Account, opts.IAMRoleName)
+		add("InstanceProfile", []string{"iam:ListInstanceProfilesForRole", "iam:GetInstanceProfile", "iam:CreateInstanceProfile", "iam:AddRoleToInstanceProfile"}, []string{roleArn, profileArn}, nil)
+		add("PassInstanceRole", []string{"iam:PassRole"}, []string{roleArn}, map[string]map[string]any{
+			"StringEquals": {"iam:PassedToService": "ec2.amazonaws.com"},
+		})
+		if opts.CreateIAMRole {
+			createCondition := map[string]map[string]any(nil)
+			if opts.PermissionsBoundary != "" {
+				createCondition = map[string]map[string]any{"StringEquals": {"iam:PermissionsBoundary": opts.PermissionsBoundary}}
+			}
+			add("CreateInstanceRole", []string{"iam:CreateRole"}, []string{roleArn}, createCondition)
+			add("ConfigureInstanceRole", []string{"iam:GetRole", "iam:TagRole", "iam:PutRolePolicy"}, []string{roleArn}, nil)
+			policyArns := append([]any{fmt.Sprintf("arn:%s:%s", opts.Partition, ssmManagedInstancePolicy)}, toAnySlice(opts.RolePolicyArns)...)
+			add("AttachInstanceRolePolicies", []string{"iam:AttachRolePolicy"}, []string{roleArn}, map[string]map[string]any{
+				"ArnEquals": {"iam:PolicyARN": policyArns},
+			})
+		}
+	}
+
+	if copies {
+		bucket := orWildcard(opts.S3Bucket)
+		add("StageCopiedFiles", []string{"s3:PutObject", "s3:GetObject", "s3:DeleteObject"}, []string{fmt.Sprintf("arn:%s:s3:::%s/%s", opts.Partition, bucket, path.Join(opts.S3KeyPrefix, "*"))}, nil)
+	}
+
+	switch {
+	case strings.HasPrefix(opts.ProfileConfig, "s3://"):
+		objectArn := fmt.Sprintf("arn:%s:s3:::%s", opts.Partition, strings.TrimPrefix(opts.ProfileConfig, "s3://"))
+		add("ReadProfileConfig", []string{"s3:GetObject"}, []string{objectArn}, nil)
+	case strings.HasPrefix(opts.ProfileConfig, "ssm:"):
+		// A parameter can be named by its ARN, and a name in a hierarchy starts with a slash that isn't in its ARN.
+		parameterArn := strings.TrimPrefix(opts.ProfileConfig, "ssm:")
+		if !strings.HasPrefix(parameterArn, "arn:") {
+			parameterArn = fmt.Sprintf("arn:%s:ssm:%s:%s:parameter/%s", opts.Partition, orWildcard(opts.ProfileConfigRegion), opts.Account, strings.TrimPrefix(parameterArn, "/"))
+		}
+		add("ReadProfileConfig", []string{"ssm:GetParameter"}, []string{parameterArn}, nil)
+	}
+
+	if opts.DecodeAuthorization {
+		add("DecodeAuthorizationMessages", []string{"sts:DecodeAuthorizationMessage"}, []string{"*"}, nil)
+	}
+
+	return PolicyDocument{Version: "2012-10-17", Statement: statements}
+}
+
+// resourceTags returns the tags of resourceType in tagSpecifications.
+func resourceTags(tagSpecifications []ec2Types.TagSpecification, resourceType ec2Types.ResourceType) map[string]string {
+	tags := map[string]string{}
+	for _, spec := range tagSpecifications {
+		if spec.ResourceType != resourceType {
+			continue
+		}
+		for _, tag := range spec.Tags {
+			if tag.Key != nil && tag.Value != nil {
+				tags[*tag.Key] = *tag.Value
+			}
+		}
+	}
+	return tags
+}
+
+// ParsePolicyModes returns the modes in entries, or all modes if there are none.
+func ParsePolicyModes(entries []string) ([]string, error) {
+	if len(entries) == 0 {
+		return policyModes, nil
+	}
+	supported := map[string]bool{}
+	for _, mode := range policyModes {
+		supported[mode] = true
+	}
+	var modes []string
+	for _, mode := range entries {
+		if !supported[mode] {
+			return nil, fmt.Errorf("unknown mode %q", mode)
+		}
+		modes = append(modes, mode)
+	}
+	return modes, nil
+}
+
+// regionPartition returns the partition of a region, e.g. "aws-cn" for cn-north-1.
+func regionPartition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	default:
+		return "aws"
+	}
+}
+
+// orWildcard returns s, or "*" if s is empty.
+func orWildcard(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
+// toAnySlice converts a slice of strings for use as a policy condition value.
+func toAnySlice(values []string) []any {
+	var result []any
+	for _, v := range values {
+		result = append(result, v)
+	}
+	return result
+}
@@ -0,0 +1,203 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// findStatement returns the statement of policy with the given Sid, or nil if there is none.
+func findStatement(policy PolicyDocument, sid string) *PolicyStatement {
+	for i := range policy.Statement {
+		if policy.Statement[i].Sid == sid {
+			return &policy.Statement[i]
+		}
+	}
+	return nil
+}
+
+func TestBuildIAMPolicyStart(t *testing.T) {
+	policy := BuildIAMPolicy(PolicyOptions{
+		Modes:          []string{"start"},
+		Partition:      "aws",
+		Account:        "123456789012",
+		Targets:        []RegionTarget{{Region: "us-east-1", ImageId: "ami-1", SubnetId: "subnet-1", SecurityGroupId: "sg-1"}},
+		InstanceTags:   map[string]string{"Purpose": "ci"},
+		IAMRoleName:    "runner",
+		CreateIAMRole:  true,
+		RolePolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+		Readiness:      []ReadinessCondition{{Name: ReadyRunning}, {Name: ReadySSMOnline}},
+	})
+
+	launch := findStatement(policy, "LaunchInstanceResources")
+	if launch == nil {
+		t.Fatalf("expected a LaunchInstanceResources statement, got %v", policy)
+	}
+	wantResources := []string{
+		"arn:aws:ec2:us-east-1::image/ami-1",
+		"arn:aws:ec2:us-east-1:123456789012:subnet/subnet-1",
+		"arn:aws:ec2:us-east-1:123456789012:security-group/sg-1",
+		"arn:aws:ec2:us-east-1:123456789012:network-interface/*",
+	}
+	if !reflect.DeepEqual(launch.Resource, wantResources) {
+		t.Fatalf("expected resources %v, got %v", wantResources, launch.Resource)
+	}
+
+	instances := findStatement(policy, "LaunchInstances")
+	if instances == nil || instances.Condition["StringEquals"]["aws:RequestTag/Purpose"] != "ci" {
+		t.Fatalf("expected RunInstances to require the Purpose tag, got %v", instances)
+	}
+	// Only the instance is tagged, so its volumes can't be required to have the instance's tags.
+	volumes := findStatement(policy, "LaunchVolumes")
+	if volumes == nil || volumes.Condition != nil || !reflect.DeepEqual(volumes.Resource, []string{"arn:aws:ec2:us-east-1:123456789012:volume/*"}) {
+		t.Fatalf("expected RunInstances to allow untagged volumes, got %v", volumes)
+	}
+	if tagOnLaunch := findStatement(policy, "TagOnLaunch"); tagOnLaunch == nil || !reflect.DeepEqual(tagOnLaunch.Resource, []string{"arn:aws:ec2:us-east-1:123456789012:instance/*"}) {
+		t.Fatalf("expected tagging only instances on launch, got %v", tagOnLaunch)
+	}
+
+	tagged := BuildIAMPolicy(PolicyOptions{
+		Modes:        []string{"start"},
+		Partition:    "aws",
+		Account:      "123456789012",
+		Targets:      []RegionTarget{{Region: "us-east-1"}},
+		InstanceTags: map[string]string{"Purpose": "ci"},
+		VolumeTags:   map[string]string{"Disk": "root"},
+	})
+	if volumes := findStatement(tagged, "LaunchVolumes"); volumes == nil || volumes.Condition["StringEquals"]["aws:RequestTag/Disk"] != "root" || volumes.Condition["StringEquals"]["aws:RequestTag/Purpose"] != nil {
+		t.Fatalf("expected RunInstances to require only the volume tags of volumes, got %v", volumes)
+	}
+	if tagOnLaunch := findStatement(tagged, "TagOnLaunch"); tagOnLaunch == nil || len(tagOnLaunch.Resource) != 2 {
+		t.Fatalf("expected tagging instances and volumes on launch, got %v", tagOnLaunch)
+	}
+
+	passRole := findStatement(policy, "PassInstanceRole")
+	if passRole == nil || !reflect.DeepEqual(passRole.Resource, []string{"arn:aws:iam::123456789012:role/runner"}) {
+		t.Fatalf("expected iam:PassRole on the instance role, got %v", passRole)
+	}
+	if passRole.Condition["StringEquals"]["iam:PassedToService"] != "ec2.amazonaws.com" {
+		t.Fatalf("expected iam:PassRole to be limited to EC2, got %v", passRole.Condition)
+	}
+
+	attach := findStatement(policy, "AttachInstanceRolePolicies")
+	wantArns := []any{"arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore", "arn:aws:iam::aws:policy/ReadOnlyAccess"}
+	if attach == nil || !reflect.DeepEqual(attach.Condition["ArnEquals"]["iam:PolicyARN"], wantArns) {
+		t.Fatalf("expected iam:AttachRolePolicy to be limited to %v, got %v", wantArns, attach)
+	}
+
+	if findStatement(policy, "DescribeSSMInstances") == nil {
+		t.Fatalf("expected ssm:DescribeInstanceInformation for ssm-online")
+	}
+	for _, sid := range []string{"SendCommandDocument", "TerminateInstances", "StageCopiedFiles"} {
+		if findStatement(policy, sid) != nil {
+			t.Fatalf("expected no %s statement", sid)
+		}
+	}
+}
+
+func TestBuildIAMPolicyCommand(t *testing.T) {
+	policy := BuildIAMPolicy(PolicyOptions{
+		Modes:     []string{"command", "stop"},
+		Partition: "aws",
+		Account:   "*",
+		Targets:   []RegionTarget{{Region: "eu-west-1"}},
+	})
+
+	if findStatement(policy, "LaunchInstances") != nil || findStatement(policy, "PassInstanceRole") != nil {
+		t.Fatalf("expected no start permissions, got %v", policy)
+	}
+	document := findStatement(policy, "SendCommandDocument")
+	if document == nil || !reflect.DeepEqual(document.Resource, []string{"arn:aws:ssm:eu-west-1::document/AWS-RunShellScript"}) {
+		t.Fatalf("expected ssm:SendCommand on the AWS-RunShellScript document, got %v", document)
+	}
+	terminate := findStatement(policy, "TerminateInstances")
+	if terminate == nil || terminate.Condition != nil {
+		t.Fatalf("expected an unconditional TerminateInstances statement without instance tags, got %v", terminate)
+	}
+	if describe := findStatement(policy, "DescribeInstances"); describe == nil || !reflect.DeepEqual(describe.Action, []string{"ec2:DescribeInstances"}) {
+		t.Fatalf("expected ec2:DescribeInstances for the stop job summary, got %v", describe)
+	}
+}
+
+func TestBuildIAMPolicyCopy(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"":                  "arn:aws-cn:s3:::bucket/*",
+		"ec2-github-runner": "arn:aws-cn:s3:::bucket/ec2-github-runner/*",
+	} {
+		policy := BuildIAMPolicy(PolicyOptions{
+			Modes:       []string{"copy-to-instance"},
+			Partition:   regionPartition("cn-north-1"),
+			Account:     "*",
+			S3Bucket:    "bucket",
+			S3KeyPrefix: prefix,
+		})
+		stage := findStatement(policy, "StageCopiedFiles")
+		if stage == nil || !reflect.DeepEqual(stage.Resource, []string{want}) {
+			t.Fatalf("expected S3 access to %s, got %v", want, stage)
+		}
+	}
+}
+
+func TestBuildIAMPolicyProfileConfig(t *testing.T) {
+	tests := []struct {
+		profileConfig string
+		// action and resource are the permission expected to read the profile config, or empty if none is.
+		action, resource string
+	}{
+		{"s3://config-bucket/ci/ec2-runner.yml", "s3:GetObject", "arn:aws:s3:::config-bucket/ci/ec2-runner.yml"},
+		{"ssm:/ci/ec2-runner", "ssm:GetParameter", "arn:aws:ssm:eu-west-1:123456789012:parameter/ci/ec2-runner"},
+		{"ssm:ec2-runner", "ssm:GetParameter", "arn:aws:ssm:eu-west-1:123456789012:parameter/ec2-runner"},
+		{"ssm:arn:aws:ssm:us-east-1:210987654321:parameter/shared", "ssm:GetParameter", "arn:aws:ssm:us-east-1:210987654321:parameter/shared"},
+		{".github/ec2-runner.yml", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profileConfig, func(t *testing.T) {
+			policy := BuildIAMPolicy(PolicyOptions{
+				Modes:               []string{"stop"},
+				Partition:           "aws",
+				Account:             "123456789012",
+				ProfileConfig:       tt.profileConfig,
+				ProfileConfigRegion: "eu-west-1",
+			})
+			read := findStatement(policy, "ReadProfileConfig")
+			if tt.action == "" {
+				if read != nil {
+					t.Fatalf("expected no permission to read the profile config, got %v", read)
+				}
+				return
+			}
+			if read == nil || !reflect.DeepEqual(read.Action, []string{tt.action}) || !reflect.DeepEqual(read.Resource, []string{tt.resource}) {
+				t.Fatalf("expected %s on %s, got %v", tt.action, tt.resource, read)
+			}
+		})
+	}
+}
+
+func TestParsePolicyModes(t *testing.T) {
+	modes, err := ParsePolicyModes(nil)
+	if err != nil || !reflect.DeepEqual(modes, policyModes) {
+		t.Fatalf("expected all modes, got %v, %v", modes, err)
+	}
+	modes, err = ParsePolicyModes([]string{"start", "stop"})
+	if err != nil || !reflect.DeepEqual(modes, []string{"start", "stop"}) {
+		t.Fatalf("expected start and stop, got %v, %v", modes, err)
+	}
+	if _, err := ParsePolicyModes([]string{"print-iam-policy"}); err == nil {
+		t.Fatalf("expected an error for an unsupported mode")
+	}
+}
+
+func TestResourceTags(t *testing.T) {
+	tagSpecifications, err := ParseTagSpecifications(`[{"ResourceType":"instance","Tags":[{"Key":"Purpose","Value":"ci"}]},{"ResourceType":"volume","Tags":[{"Key":"Disk","Value":"root"}]}]`)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if tags := resourceTags(tagSpecifications, ec2Types.ResourceTypeInstance); !reflect.DeepEqual(tags, map[string]string{"Purpose": "ci"}) {
+		t.Fatalf("expected only the instance tags, got %v", tags)
+	}
+	if tags := resourceTags(tagSpecifications, ec2Types.ResourceTypeVolume); !reflect.DeepEqual(tags, map[string]string{"Disk": "root"}) {
+		t.Fatalf("expected only the volume tags, got %v", tags)
+	}
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// PreflightStart checks the launch parameters of a region target before anything is created, so that
+// misconfigurations are reported together instead of as the first RunInstances error. It checks that the AMI exists
+// and is available, that the subnet and security group are in the same VPC, that the instance type is offered in the
+// subnet's availability zone and that it supports the AMI's architecture. It returns the problems found.
+func PreflightStart(ctx context.Context, logger Logger, ec2Client EC2API, target RegionTarget, instanceType string) []string {
+	var problems []string
+
+	var imageArch ec2Types.ArchitectureValues
+	images, err := ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{target.ImageId}})
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("error describing AMI %s in region %s: %v", target.ImageId, target.Region, err))
+	case len(images.Images) == 0:
+		problems = append(problems, fmt.Sprintf("AMI %s does not exist in region %s", target.ImageId, target.Region))
+	case images.Images[0].State != ec2Types.ImageStateAvailable:
+		problems = append(problems, fmt.Sprintf("AMI %s in region %s is %s, not available", target.ImageId, target.Region, images.Images[0].State))
+	default:
+		imageArch = images.Images[0].Architecture
+	}
+
+	var vpcId, availabilityZone string
+	subnets, err := ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: []string{target.SubnetId}})
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("error describing subnet %s in region %s: %v", target.SubnetId, target.Region, err))
+	case len(subnets.Subnets) == 0:
+		problems = append(problems, fmt.Sprintf("subnet %s does not exist in region %s", target.SubnetId, target.Region))
+	default:
+		vpcId = aws.ToString(subnets.Subnets[0].VpcId)
+		availabilityZone = aws.ToString(subnets.Subnets[0].AvailabilityZone)
+	}
+
+	groups, err := ec2Client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{target.SecurityGroupId}})
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("error describing security group %s in region %s: %v", target.SecurityGroupId, target.Region, err))
+	case len(groups.SecurityGroups) == 0:
+		problems = append(problems, fmt.Sprintf("security group %s does not exist in region %s", target.SecurityGroupId, target.Region))
+	case vpcId != "":
+		for _, group := range groups.SecurityGroups {
+			if groupVpcId := aws.ToString(group.VpcId); groupVpcId != vpcId {
+				problems = append(problems, fmt.Sprintf("security group %s is in %s but subnet %s is in %s", aws.ToString(group.GroupId), groupVpcId, target.SubnetId, vpcId))
+			}
+		}
+	}
+
+	if availabilityZone != "" {
+		offerings, err := ec2Client.DescribeInstanceTypeOfferings(ctx, &ec2.DescribeInstanceTypeOfferingsInput{
+			LocationType: ec2Types.LocationTypeAvailabilityZone,
+			Filters: []ec2Types.Filter{
+				{Name: aws.String("instance-type"), Values: []string{instanceType}},
+				{Name: aws.String("location"), Values: []string{availabilityZone}},
+			},
+		})
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("error describing instance type offerings in %s: %v", availabilityZone, err))
+		case len(offerings.InstanceTypeOfferings) == 0:
+			problems = append(problems, fmt.Sprintf("instance type %s is not offered in %s, the availability zone of subnet %s", instanceType, availabilityZone, target.SubnetId))
+		}
+	}
+
+	types, err := ec2Client.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{InstanceTypes: []ec2Types.InstanceType{ec2Types.InstanceType(instanceType)}})
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("error describing instance type %s in region %s: %v", instanceType, target.Region, err))
+	case len(types.InstanceTypes) == 0 || types.InstanceTypes[0].ProcessorInfo == nil:
+		problems = append(problems, fmt.Sprintf("instance type %s does not exist in region %s", instanceType, target.Region))
+	case imageArch != "":
+		supported := types.InstanceTypes[0].ProcessorInfo.SupportedArchitectures
+		if !supportsArchitecture(supported, imageArch) {
+			var names []string
+			for _, arch := range supported {
+				names = append(names, string(arch))
+			}
+			problems = append(problems, fmt.Sprintf("AMI %s is %s but instance type %s supports %s", target.ImageId, imageArch, instanceType, strings.Join(names, ", ")))
+		}
+	}
+
+	if len(problems) == 0 {
+		logger.Infof("Preflight checks passed for %s with AMI %s in subnet %s (%s)", instanceType, target.ImageId, target.SubnetId, availabilityZone)
+	}
+	return problems
+}
+
+// supportsArchitecture reports whether an AMI architecture is one of the architectures supported by an instance type.
+func supportsArchitecture(supported []ec2Types.ArchitectureType, arch ec2Types.ArchitectureValues) bool {
+	for _, s := range supported {
+		if string(s) == string(arch) {
+			return true
+		}
+	}
+	return false
+}
+
+// listProblems formats problems as a count followed by a bulleted list, for error messages.
+func listProblems(problems []string) string {
+	return fmt.Sprintf("%d problem(s):\n- %s", len(problems), strings.Join(problems, "\n- "))
+}
@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// PreflightEC2Client describes an AMI, security group and instance type that are incompatible with each other in
+// the ways set, e.g. a security group in another VPC than the subnet.
+type PreflightEC2Client struct {
+	MockEC2Client
+	imageMissing     bool
+	imageState       ec2Types.ImageState
+	imageArch        ec2Types.ArchitectureValues
+	securityGroupVpc string
+	typeNotOffered   bool
+}
+
+func (m *PreflightEC2Client) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	if m.imageMissing {
+		return nil, &smithy.GenericAPIError{Code: "InvalidAMIID.NotFound", Message: "The image id does not exist"}
+	}
+	image := ec2Types.Image{ImageId: aws.String(params.ImageIds[0]), State: ec2Types.ImageStateAvailable, Architecture: ec2Types.ArchitectureValuesX8664}
+	if m.imageState != "" {
+		image.State = m.imageState
+	}
+	if m.imageArch != "" {
+		image.Architecture = m.imageArch
+	}
+	return &ec2.DescribeImagesOutput{Images: []ec2Types.Image{image}}, nil
+}
+
+func (m *PreflightEC2Client) DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if m.securityGroupVpc == "" {
+		return m.MockEC2Client.DescribeSecurityGroups(ctx, params, optFns...)
+	}
+	var groups []ec2Types.SecurityGroup
+	for _, id := range params.GroupIds {
+		groups = append(groups, ec2Types.SecurityGroup{GroupId: aws.String(id), VpcId: aws.String(m.securityGroupVpc)})
+	}
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: groups}, nil
+}
+
+func (m *PreflightEC2Client) DescribeInstanceTypeOfferings(ctx context.Context, params *ec2.DescribeInstanceTypeOfferingsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+	if m.typeNotOffered {
+		return &ec2.DescribeInstanceTypeOfferingsOutput{}, nil
+	}
+	return m.MockEC2Client.DescribeInstanceTypeOfferings(ctx, params, optFns...)
+}
+
+func TestPreflightStart(t *testing.T) {
+	target := RegionTarget{Region: "us-east-1", ImageId: "ami-12345678", SubnetId: "subnet-12345678", SecurityGroupId: "sg-12345678"}
+
+	tests := []struct {
+		name   string
+		client *PreflightEC2Client
+		// problems are substrings of the problems expected, in order.
+		problems []string
+	}{
+		{"compatible", &PreflightEC2Client{}, nil},
+		{"missing AMI", &PreflightEC2Client{imageMissing: true}, []string{"InvalidAMIID.NotFound"}},
+		{"pending AMI", &PreflightEC2Client{imageState: ec2Types.ImageStatePending}, []string{"is pending, not available"}},
+		{"security group in other VPC", &PreflightEC2Client{securityGroupVpc: "vpc-2"}, []string{"is in vpc-2 but subnet subnet-12345678 is in vpc-1"}},
+		{"instance type not offered", &PreflightEC2Client{typeNotOffered: true}, []string{"is not offered in us-east-1a"}},
+		{"architecture mismatch", &PreflightEC2Client{imageArch: ec2Types.ArchitectureValuesArm64}, []string{"AMI ami-12345678 is arm64 but instance type t3.micro supports i386, x86_64"}},
+		{"every problem", &PreflightEC2Client{imageState: ec2Types.ImageStateFailed, securityGroupVpc: "vpc-2", typeNotOffered: true}, []string{"is failed", "is in vpc-2", "is not offered"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := PreflightStart(context.Background(), githubactions.New(), tt.client, target, "t3.micro")
+			if len(problems) != len(tt.problems) {
+				t.Fatalf("expected problems %v, got %v", tt.problems, problems)
+			}
+			for i, problem := range tt.problems {
+				if !strings.Contains(problems[i], problem) {
+					t.Fatalf("expected problem %d to contain %q, got %v", i, problem, problems)
+				}
+			}
+		})
+	}
+}
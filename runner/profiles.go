@@ -0,0 +1,344 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultProfileConfig is the profile config file read when the profile-config input isn't set, relative to the
+// repository.
+const defaultProfileConfig = ".github/ec2-runner.yml"
+
+// profileConfigVersion is the version of the profile config file format.
+const profileConfigVersion = 1
+
+// profileInputKind is the type of value an input takes in a profile.
+type profileInputKind int
+
+const (
+	profileString profileInputKind = iota
+	profileBool
+	profileInt
+	// profileList is a list of strings, or a string of lines.
+	profileList
+	// profileJSON is a YAML value passed to the input as JSON, or a string of JSON.
+	profileJSON
+)
+
+// profileInputs are the inputs a profile can set and the type of their values. Credentials, endpoints and the
+// region can't be set by a profile, since they may be needed to read the profile config.
+var profileInputs = map[string]profileInputKind{
+	"ec2-image-id":                  profileString,
+	"subnet-id":                     profileString,
+	"security-group-id":             profileString,
+	"iam-role-name":                 profileString,
+	"create-iam-role":               profileBool,
+	"iam-role-policy-arns":          profileList,
+	"iam-role-inline-policy":        profileJSON,
+	"iam-role-permissions-boundary": profileString,
+	"ec2-instance-type":             profileString,
+	"user-data":                     profileString,
+	"tag-specifications":            profileJSON,
+	"wait-for":                      profileList,
+	"instance-max-wait-secs":        profileInt,
+	"terminate-on-cancel":           profileBool,
+	"skip-preflight":                profileBool,
+	"regions":                       profileList,
+}
+
+// ProfileConfig is a file of named runner profiles, each of which sets inputs of the Action, e.g.
+//
+//	version: 1
+//	profiles:
+//	  base:
+//	    subnet-id: subnet-0123456789abcdef0
+//	    security-group-id: sg-0123456789abcdef0
+//	  linux-large:
+//	    extends: base
+//	    ec2-image-id: ami-0123456789abcdef0
+//	    ec2-instance-type: m5.xlarge
+type ProfileConfig struct {
+	// Source is where the config was read from, for error messages.
+	Source   string
+	Profiles map[string]Profile
+}
+
+// Profile is a named set of inputs, which override the inputs of the profile it extends, if any.
+type Profile struct {
+	Extends string
+	Inputs  map[string]string
+	// extendsLine is the line of extends in the config file, for error messages.
+	extendsLine int
+}
+
+// ParseProfileConfig parses and validates a profile config file read from source. All the problems found are
+// returned as one error, each with its line number.
+func ParseProfileConfig(data []byte, source string) (*ProfileConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid profile config %s: %v", source, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("invalid profile config %s: the file is empty", source)
+	}
+
+	config := &ProfileConfig{Source: source, Profiles: map[string]Profile{}}
+	var problems []string
+	problem := func(node *yaml.Node, format string, args ...any) {
+		problems = append(problems, fmt.Sprintf("line %d: %s", node.Line, fmt.Sprintf(format, args...)))
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		problem(root, "expected a mapping with version and profiles")
+		return nil, fmt.Errorf("invalid profile config %s: %s", source, listProblems(problems))
+	}
+	var hasVersion bool
+	var names []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "version":
+			hasVersion = true
+			if version, err := strconv.Atoi(value.Value); err != nil || value.Kind != yaml.ScalarNode || version != profileConfigVersion {
+				problem(value, "unsupported version %q, expected %d", value.Value, profileConfigVersion)
+			}
+		case "profiles":
+			if value.Kind != yaml.MappingNode {
+				problem(value, "expected profiles to be a mapping of profile names to inputs")
+				continue
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				name, body := value.Content[j], value.Content[j+1]
+				if _, ok := config.Profiles[name.Value]; ok {
+					problem(name, "duplicate profile %s", name.Value)
+					continue
+				}
+				profile, profileProblems := parseProfile(name.Value, body)
+				problems = append(problems, profileProblems...)
+				config.Profiles[name.Value] = profile
+				names = append(names, name.Value)
+			}
+		default:
+			problem(key, "unknown key %q, expected version or profiles", key.Value)
+		}
+	}
+	if !hasVersion {
+		problem(root, "missing version, expected version: %d", profileConfigVersion)
+	}
+	for _, name := range names {
+		profile := config.Profiles[name]
+		if profile.Extends == "" {
+			continue
+		}
+		if _, ok := config.Profiles[profile.Extends]; !ok {
+			problems = append(problems, fmt.Sprintf("line %d: profile %s extends unknown profile %q", profile.extendsLine, name, profile.Extends))
+		} else if _, err := config.Inputs(name); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", profile.extendsLine, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid profile config %s: %s", source, listProblems(problems))
+	}
+	return config, nil
+}
+
+// parseProfile parses the inputs of a profile, returning the problems found with them.
+func parseProfile(name string, node *yaml.Node) (Profile, []string) {
+	profile := Profile{Inputs: map[string]string{}}
+	var problems []string
+	if node.Kind != yaml.MappingNode {
+		return profile, []string{fmt.Sprintf("line %d: expected profile %s to be a mapping of inputs", node.Line, name)}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "extends" {
+			if value.Kind != yaml.ScalarNode || value.Value == "" {
+				problems = append(problems, fmt.Sprintf("line %d: expected extends to be the name of a profile", value.Line))
+			}
+			profile.Extends, profile.extendsLine = value.Value, value.Line
+			continue
+		}
+		kind, ok := profileInputs[key.Value]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("line %d: unknown input %q in profile %s, profiles can set %s", key.Line, key.Value, name, strings.Join(sortedKeys(profileInputs), ", ")))
+			continue
+		}
+		v, err := profileInputValue(key.Value, kind, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: invalid value for %s in profile %s: %v", value.Line, key.Value, name, err))
+			continue
+		}
+		profile.Inputs[key.Value] = v
+	}
+	return profile, problems
+}
+
+// profileInputValue returns the value of an input in a profile as the string the input would be set to.
+func profileInputValue(name string, kind profileInputKind, node *yaml.Node) (string, error) {
+	isString := node.Kind == yaml.ScalarNode && node.Tag != "!!null"
+	var v string
+	switch kind {
+	case profileString:
+		if !isString {
+			return "", fmt.Errorf("expected a string")
+		}
+		v = node.Value
+	case profileBool:
+		var b bool
+		if !isString || node.Decode(&b) != nil {
+			return "", fmt.Errorf("expected true or false")
+		}
+		v = strconv.FormatBool(b)
+	case profileInt:
+		var i int
+		if !isString || node.Decode(&i) != nil || i <= 0 {
+			return "", fmt.Errorf("expected a positive number")
+		}
+		v = strconv.Itoa(i)
+	case profileList:
+		switch {
+		case isString:
+			v = node.Value
+		case node.Kind == yaml.SequenceNode:
+			var items []string
+			if err := node.Decode(&items); err != nil {
+				return "", fmt.Errorf("expected a list of strings")
+			}
+			v = strings.Join(items, "\n")
+		default:
+			return "", fmt.Errorf("expected a string or a list of strings")
+		}
+	case profileJSON:
+		if isString {
+			v = node.Value
+			break
+		}
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return "", err
+		}
+		document, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		v = string(document)
+	}
+
+	// Check the values of inputs with a syntax of their own, so that mistakes are reported with their line.
+	switch name {
+	case "wait-for":
+		if _, err := ParseReadinessConditions(strings.Split(v, "\n"), defaultReadinessTimeouts[ReadyRunning]); err != nil {
+			return "", err
+		}
+	case "tag-specifications":
+		if _, err := ParseTagSpecifications(v); err != nil {
+			return "", err
+		}
+	}
+	return v, nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Inputs returns the inputs of the named profile, including those of the profiles it extends, which it overrides.
+func (c *ProfileConfig) Inputs(name string) (map[string]string, error) {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q is not in %s, its profiles are %s", name, c.Source, strings.Join(sortedKeys(c.Profiles), ", "))
+	}
+	chain := []Profile{profile}
+	seen := map[string]bool{name: true}
+	for parent := profile.Extends; parent != ""; parent = chain[len(chain)-1].Extends {
+		if seen[parent] {
+			return nil, fmt.Errorf("profile %s extends itself through %s", name, parent)
+		}
+		seen[parent] = true
+		p, ok := c.Profiles[parent]
+		if !ok {
+			return nil, fmt.Errorf("profile %s extends unknown profile %q", name, parent)
+		}
+		chain = append(chain, p)
+	}
+
+	inputs := map[string]string{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for k, v := range chain[i].Inputs {
+			inputs[k] = v
+		}
+	}
+	return inputs, nil
+}
+
+// LoadProfileConfig reads and parses the profile config at location, which is an s3://bucket/key URL, "ssm:"
+// followed by the name of an SSM parameter, or otherwise the path of a file relative to dir. clients returns the
+// clients to read S3 and SSM with, and is only called for a config in one of them.
+func LoadProfileConfig(ctx context.Context, location, dir string, clients func() (S3API, SSMParameterAPI, error)) (*ProfileConfig, error) {
+	var data []byte
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		if bucket == "" || key == "" {
+			return nil, fmt.Errorf("invalid profile config location %q, expected s3://bucket/key", location)
+		}
+		s3Client, _, err := clients()
+		if err != nil {
+			return nil, err
+		}
+		object, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			return nil, fmt.Errorf("error reading profile config %s: %w", location, err)
+		}
+		defer object.Body.Close()
+		if data, err = io.ReadAll(object.Body); err != nil {
+			return nil, fmt.Errorf("error reading profile config %s: %w", location, err)
+		}
+	case strings.HasPrefix(location, "ssm:"):
+		name := strings.TrimPrefix(location, "ssm:")
+		if name == "" {
+			return nil, fmt.Errorf("invalid profile config location %q, expected ssm:parameter-name", location)
+		}
+		_, ssmClient, err := clients()
+		if err != nil {
+			return nil, err
+		}
+		parameter, err := ssmClient.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+		if err != nil {
+			return nil, fmt.Errorf("error reading profile config %s: %w", location, err)
+		}
+		if parameter.Parameter != nil {
+			data = []byte(aws.ToString(parameter.Parameter.Value))
+		}
+	default:
+		path := location
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("error reading profile config: %w", err)
+		}
+	}
+	return ParseProfileConfig(data, location)
+}
@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/sethvargo/go-githubactions"
+)
+
+const testProfileConfig = `version: 1
+profiles:
+  base:
+    subnet-id: subnet-12345678
+    security-group-id: sg-12345678
+    wait-for: [ssm-online]
+    tag-specifications:
+      - ResourceType: instance
+        Tags: [{Key: Purpose, Value: ci}]
+  linux-large:
+    extends: base
+    ec2-image-id: ami-12345678
+    ec2-instance-type: m5.xlarge
+    terminate-on-cancel: true
+`
+
+type MockSSMParameterClient struct {
+	parameters map[string]string
+}
+
+func (m *MockSSMParameterClient) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	return &ssm.GetParameterOutput{Parameter: &ssmTypes.Parameter{Value: aws.String(m.parameters[aws.ToString(params.Name)])}}, nil
+}
+
+func TestProfileConfigInputs(t *testing.T) {
+	config, err := ParseProfileConfig([]byte(testProfileConfig), "profiles.yml")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	inputs, err := config.Inputs("linux-large")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := map[string]string{
+		"subnet-id":           "subnet-12345678",
+		"security-group-id":   "sg-12345678",
+		"wait-for":            "ssm-online",
+		"tag-specifications":  `[{"ResourceType":"instance","Tags":[{"Key":"Purpose","Value":"ci"}]}]`,
+		"ec2-image-id":        "ami-12345678",
+		"ec2-instance-type":   "m5.xlarge",
+		"terminate-on-cancel": "true",
+	}
+	if !reflect.DeepEqual(inputs, expected) {
+		t.Fatalf("expected %v, got %v", expected, inputs)
+	}
+	if _, err := config.Inputs("windows"); err == nil || !strings.Contains(err.Error(), "base, linux-large") {
+		t.Fatalf("expected an error listing the profiles, got %v", err)
+	}
+}
+
+func TestParseProfileConfigProblems(t *testing.T) {
+	_, err := ParseProfileConfig([]byte(`version: 2
+profiles:
+  a:
+    extends: b
+    ec2-image: ami-12345678
+  b:
+    extends: a
+    instance-max-wait-secs: soon
+    wait-for: [running, online]
+  c:
+    extends: d
+`), "profiles.yml")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, problem := range []string{
+		`line 1: unsupported version "2"`,
+		`line 5: unknown input "ec2-image" in profile a`,
+		`line 8: invalid value for instance-max-wait-secs in profile b: expected a positive number`,
+		`line 9: invalid value for wait-for in profile b: unknown readiness condition "online"`,
+		`line 4: profile a extends itself through a`,
+		`line 7: profile b extends itself through b`,
+		`line 11: profile c extends unknown profile "d"`,
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("expected %q in the error, got %s", problem, err)
+		}
+	}
+}
+
+func TestLoadProfileConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".github"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, defaultProfileConfig), []byte(testProfileConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	noClients := func() (S3API, SSMParameterAPI, error) {
+		t.Fatalf("expected no clients to be needed for a file")
+		return nil, nil, nil
+	}
+
+	ctx := context.Background()
+
+	if _, err := LoadProfileConfig(ctx, defaultProfileConfig, dir, noClients); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	clients := func() (S3API, SSMParameterAPI, error) {
+		return &MockS3Client{objects: map[string][]byte{"bucket/config/profiles.yml": []byte(testProfileConfig)}}, &MockSSMParameterClient{parameters: map[string]string{"/ec2-runner/profiles": testProfileConfig}}, nil
+	}
+	for _, location := range []string{"s3://bucket/config/profiles.yml", "ssm:/ec2-runner/profiles"} {
+		config, err := LoadProfileConfig(ctx, location, dir, clients)
+		if err != nil {
+			t.Fatalf("expected no error for %s, got %s", location, err)
+		}
+		if _, ok := config.Profiles["linux-large"]; !ok {
+			t.Fatalf("expected the profiles from %s, got %v", location, config.Profiles)
+		}
+	}
+	if _, err := LoadProfileConfig(ctx, "s3://bucket", dir, clients); err == nil {
+		t.Fatalf("expected an error for an S3 URL without a key")
+	}
+}
+
+func TestWithProfileInputs(t *testing.T) {
+	env := map[string]string{"INPUT_EC2-INSTANCE-TYPE": "t3.large", "INPUT_SUBNET-ID": ""}
+	action := githubactions.New(githubactions.WithGetenv(func(key string) string { return env[key] }))
+
+	action = withProfileInputs(action, map[string]string{"ec2-instance-type": "m5.xlarge", "subnet-id": "subnet-12345678"})
+	if v := action.GetInput("ec2-instance-type"); v != "t3.large" {
+		t.Fatalf("expected the input to override the profile, got %s", v)
+	}
+	if v := action.GetInput("subnet-id"); v != "subnet-12345678" {
+		t.Fatalf("expected the profile's subnet, got %s", v)
+	}
+}
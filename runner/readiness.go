@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Readiness conditions that can be awaited after an instance is launched, in the order they are checked.
+const (
+	ReadyRunning          = "running"
+	ReadyStatusOk         = "status-ok"
+	ReadySSMOnline        = "ssm-online"
+	ReadyUserDataComplete = "user-data-complete"
+)
+
+// readinessOrder lists the readiness conditions in the order they are checked, since each implies the previous
+// ones can be met: status checks only run once the instance is running, and user data is checked over SSM.
+var readinessOrder = []string{ReadyRunning, ReadyStatusOk, ReadySSMOnline, ReadyUserDataComplete}
+
+// defaultReadinessTimeouts are the default number of seconds to wait for each readiness condition.
+var defaultReadinessTimeouts = map[string]int{
+	ReadyRunning:          300,
+	ReadyStatusOk:         600,
+	ReadySSMOnline:        300,
+	ReadyUserDataComplete: 900,
+}
+
+// ReadinessCondition is a condition an instance must meet before it is considered ready,
+// and the number of seconds to wait for it.
+type ReadinessCondition struct {
+	Name    string
+	Timeout int
+}
+
+// ParseReadinessConditions parses readiness conditions of the form "name" or "name:timeout-secs".
+// The running condition is always included, using runningTimeout unless a timeout is given for it, and
+// user-data-complete implies ssm-online. The conditions are returned in the order they must be checked.
+func ParseReadinessConditions(entries []string, runningTimeout int) ([]ReadinessCondition, error) {
+	timeouts := map[string]int{ReadyRunning: runningTimeout}
+	for _, entry := range entries {
+		for _, item := range strings.Split(entry, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			name, secs, hasTimeout := strings.Cut(item, ":")
+			if _, ok := defaultReadinessTimeouts[name]; !ok {
+				return nil, fmt.Errorf("unknown readiness condition %q, supported conditions are %s", name, strings.Join(readinessOrder, ", "))
+			}
+			timeout := defaultReadinessTimeouts[name]
+			if name == ReadyRunning {
+				timeout = runningTimeout
+			}
+			if hasTimeout {
+				var err error
+				if timeout, err = strconv.Atoi(secs); err != nil || timeout <= 0 {
+					return nil, fmt.Errorf("invalid timeout %q for readiness condition %s", secs, name)
+				}
+			}
+			timeouts[name] = timeout
+		}
+	}
+	if _, ok := timeouts[ReadyUserDataComplete]; ok {
+		if _, ok := timeouts[ReadySSMOnline]; !ok {
+			timeouts[ReadySSMOnline] = defaultReadinessTimeouts[ReadySSMOnline]
+		}
+	}
+
+	var conditions []ReadinessCondition
+	for _, name := range readinessOrder {
+		if timeout, ok := timeouts[name]; ok {
+			conditions = append(conditions, ReadinessCondition{Name: name, Timeout: timeout})
+		}
+	}
+	return conditions, nil
+}
+
+// ReadinessPhase is how long an instance took to meet a readiness condition.
+type ReadinessPhase struct {
+	Condition string
+	Duration  time.Duration
+}
+
+// WaitForInstanceReady waits for an EC2 instance to meet each of the readiness conditions in turn,
+// logging how long each one took. It returns how long each condition that was met took, and an error for the
+// first condition that isn't met in time.
+func WaitForInstanceReady(ctx context.Context, logger Logger, ec2Client EC2API, ssmClient SSMAPI, instanceId string, conditions []ReadinessCondition) ([]ReadinessPhase, error) {
+	start := now(ctx)
+	var phases []ReadinessPhase
+	for _, condition := range conditions {
+		conditionStart := now(ctx)
+		logger.Infof("Waiting up to %d seconds for instance %s to be %s", condition.Timeout, instanceId, condition.Name)
+
+		var err error
+		switch condition.Name {
+		case ReadyRunning:
+			err = WaitForInstanceRunning(ctx, logger, ec2Client, instanceId, condition.Timeout, 5)
+		case ReadyStatusOk:
+			err = WaitForInstanceStatusOk(ctx, logger, ec2Client, instanceId, condition.Timeout, 10)
+		case ReadySSMOnline:
+			var online bool
+			online, err = IsSSMAgentRegistered(ctx, logger, ssmClient, instanceId, condition.Timeout, ssmAgentPollInterval)
+			if err == nil && !online {
+				err = fmt.Errorf("SSM agent is not registered or online for instance %s after %d seconds", instanceId, condition.Timeout)
+			}
+		case ReadyUserDataComplete:
+			err = WaitForUserDataComplete(ctx, logger, ssmClient, instanceId, condition.Timeout)
+		default:
+			err = fmt.Errorf("unknown readiness condition %q", condition.Name)
+		}
+		if err != nil {
+			return phases, fmt.Errorf("instance %s did not become %s: %w", instanceId, condition.Name, err)
+		}
+
+		phases = append(phases, ReadinessPhase{Condition: condition.Name, Duration: now(ctx).Sub(conditionStart)})
+		logger.Infof("Instance %s is %s after %s (%s since launch)", instanceId, condition.Name, now(ctx).Sub(conditionStart).Round(time.Second), now(ctx).Sub(start).Round(time.Second))
+	}
+	return phases, nil
+}
+
+// WaitForInstanceStatusOk waits for both the system and instance status checks of an EC2 instance to pass.
+// It checks the status every interval seconds and returns an error if a check reports the instance as impaired,
+// if the checks don't pass within timeout seconds, or if ctx is done.
+func WaitForInstanceStatusOk(ctx context.Context, logger Logger, ec2Client EC2API, instanceId string, timeout, interval int) error {
+	endTime := now(ctx).Add(time.Duration(timeout) * time.Second)
+
+	params := &ec2.DescribeInstanceStatusInput{
+		InstanceIds:         []string{instanceId},
+		IncludeAllInstances: aws.Bool(true),
+	}
+
+	for {
+		resp, err := ec2Client.DescribeInstanceStatus(ctx, params)
+		if err != nil {
+			return fmt.Errorf("error describing status of instance %s: %w", instanceId, err)
+		}
+
+		systemStatus, instanceStatus := ec2Types.SummaryStatusInitializing, ec2Types.SummaryStatusInitializing
+		if len(resp.InstanceStatuses) > 0 {
+			status := resp.InstanceStatuses[0]
+			if status.SystemStatus != nil {
+				systemStatus = status.SystemStatus.Status
+			}
+			if status.InstanceStatus != nil {
+				instanceStatus = status.InstanceStatus.Status
+			}
+		}
+		logger.Infof("Instance status checks: system %s, instance %s", systemStatus, instanceStatus)
+
+		if systemStatus == ec2Types.SummaryStatusOk && instanceStatus == ec2Types.SummaryStatusOk {
+			return nil
+		}
+		if systemStatus == ec2Types.SummaryStatusImpaired || instanceStatus == ec2Types.SummaryStatusImpaired {
+			return fmt.Errorf("status checks for instance %s report it as impaired (system %s, instance %s)", instanceId, systemStatus, instanceStatus)
+		}
+
+		if !now(ctx).Before(endTime) {
+			return fmt.Errorf("timed out after %d seconds waiting for status checks of instance %s to pass", timeout, instanceId)
+		}
+		if err := sleepContext(ctx, time.Duration(interval)*time.Second); err != nil {
+			return fmt.Errorf("stopped waiting for status checks of instance %s: %w", instanceId, err)
+		}
+	}
+}
+
+// WaitForUserDataComplete waits up to timeout seconds for cloud-init, which runs the instance's user data,
+// to finish on an EC2 instance. It runs "cloud-init status --wait" over SSM and returns an error if cloud-init
+// reports an error or doesn't finish in time. If it doesn't finish in time or ctx is cancelled, the command is
+// cancelled so it doesn't keep running on the instance.
+func WaitForUserDataComplete(ctx context.Context, logger Logger, ssmClient SSMAPI, instanceId string, timeout int) error {
+	commandId, details, err := ExecuteCommandOnEC2Instance(ctx, logger, ssmClient, instanceId, "cloud-init status --wait --long", defaultSSMAgentMaxWaitTime, timeout)
+	if err != nil {
+		if commandId != "" && details == nil {
+			cancelCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+			defer cancel()
+			if cerr := CancelCommand(cancelCtx, logger, ssmClient, instanceId, commandId); cerr != nil {
+				logger.Warningf("%s", cerr)
+			}
+		}
+		return fmt.Errorf("error waiting for cloud-init: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/sethvargo/go-githubactions"
+)
+
+func TestParseReadinessConditions(t *testing.T) {
+	conditions, err := ParseReadinessConditions([]string{"user-data-complete:1200, status-ok"}, 120)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	want := []ReadinessCondition{
+		{Name: ReadyRunning, Timeout: 120},
+		{Name: ReadyStatusOk, Timeout: 600},
+		{Name: ReadySSMOnline, Timeout: 300},
+		{Name: ReadyUserDataComplete, Timeout: 1200},
+	}
+	if !reflect.DeepEqual(conditions, want) {
+		t.Fatalf("expected conditions %v, got %v", want, conditions)
+	}
+
+	for _, invalid := range []string{"ready", "ssm-online:soon", "status-ok:0"} {
+		if _, err := ParseReadinessConditions([]string{invalid}, 120); err == nil {
+			t.Fatalf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestWaitForInstanceReady(t *testing.T) {
+	action := githubactions.New()
+	mockEC2 := &MockEC2Client{}
+	mockSSM := &MockSSMClient{}
+	instanceId := testEC2ClientId
+
+	ctx := context.Background()
+
+	conditions, err := ParseReadinessConditions([]string{"status-ok", "user-data-complete"}, 60)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	phases, err := WaitForInstanceReady(ctx, action, mockEC2, mockSSM, instanceId, conditions)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(phases) != len(conditions) || phases[len(phases)-1].Condition != ReadyUserDataComplete {
+		t.Fatalf("expected a phase for each condition, got %+v", phases)
+	}
+	if len(mockSSM.commands) != 1 || !strings.HasPrefix(mockSSM.commands[0], "cloud-init status --wait") {
+		t.Fatalf("expected cloud-init status command, got %v", mockSSM.commands)
+	}
+}
+
+func TestWaitForInstanceReadyUserDataFailed(t *testing.T) {
+	mockSSM := &CommandResultSSMClient{result: commandInvocationOutput(ssmTypes.CommandInvocationStatusFailed, 1, "", "status: error")}
+
+	conditions := []ReadinessCondition{{Name: ReadyUserDataComplete, Timeout: 60}}
+	_, err := WaitForInstanceReady(context.Background(), githubactions.New(), &MockEC2Client{}, mockSSM, testEC2ClientId, conditions)
+	if err == nil || !strings.Contains(err.Error(), "user-data-complete") {
+		t.Fatalf("expected user-data-complete error, got %v", err)
+	}
+}
+
+// CancelRecordingSSMClient returns result for every command invocation and records the commands cancelled.
+type CancelRecordingSSMClient struct {
+	CommandResultSSMClient
+	cancelled []string
+}
+
+func (m *CancelRecordingSSMClient) CancelCommand(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+	m.cancelled = append(m.cancelled, aws.ToString(params.CommandId))
+	return &ssm.CancelCommandOutput{}, nil
+}
+
+func TestWaitForUserDataComplete(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		result *ssm.GetCommandInvocationOutput
+		// cancelled is whether the cloud-init command is expected to be cancelled.
+		cancelled bool
+	}{
+		{"failed", context.Background(), commandInvocationOutput(ssmTypes.CommandInvocationStatusFailed, 1, "", "status: error"), false},
+		{"cancelled", cancelled, commandInvocationOutput(ssmTypes.CommandInvocationStatusInProgress, 0, "", ""), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSSM := &CancelRecordingSSMClient{CommandResultSSMClient: CommandResultSSMClient{result: tt.result}}
+			if err := WaitForUserDataComplete(tt.ctx, githubactions.New(), mockSSM, testEC2ClientId, 60); err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got := len(mockSSM.cancelled) > 0; got != tt.cancelled {
+				t.Fatalf("expected the command cancelled to be %v, got %v", tt.cancelled, mockSSM.cancelled)
+			}
+		})
+	}
+}
+
+// InstanceStatusEC2Client reports status as the instance's status check.
+type InstanceStatusEC2Client struct {
+	MockEC2Client
+	status ec2Types.SummaryStatus
+}
+
+func (m *InstanceStatusEC2Client) DescribeInstanceStatus(ctx context.Context, params *ec2.DescribeInstanceStatusInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error) {
+	return describeInstanceStatusOutput(m.status), nil
+}
+
+func TestWaitForInstanceStatusOk(t *testing.T) {
+	tests := []struct {
+		status  ec2Types.SummaryStatus
+		timeout int
+		// err is a substring of the expected error, or empty if none is expected.
+		err string
+	}{
+		{ec2Types.SummaryStatusOk, 60, ""},
+		{ec2Types.SummaryStatusImpaired, 60, "impaired"},
+		{ec2Types.SummaryStatusInitializing, 0, "timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			err := WaitForInstanceStatusOk(context.Background(), githubactions.New(), &InstanceStatusEC2Client{status: tt.status}, testEC2ClientId, tt.timeout, 0)
+			if tt.err == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
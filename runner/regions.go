@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// capacityErrorCodes are the RunInstances error codes for a lack of capacity or an exhausted quota in a region,
+// after which the launch is tried in the next region.
+var capacityErrorCodes = []string{
+	"InsufficientInstanceCapacity",
+	"InsufficientHostCapacity",
+	"InsufficientReservedInstanceCapacity",
+	"InstanceLimitExceeded",
+	"VcpuLimitExceeded",
+	"MaxSpotInstanceCountExceeded",
+}
+
+// RegionTarget is a region to launch an instance in, with the AMI, subnet and security group to use there.
+type RegionTarget struct {
+	Region          string
+	ImageId         string
+	SubnetId        string
+	SecurityGroupId string
+}
+
+// ParseRegionTargets parses an ordered list of regions to launch an instance in. Each entry is a region name,
+// optionally followed by space separated ec2-image-id=, subnet-id= and security-group-id= settings for that region.
+// Settings that aren't given are taken from defaults. Without any entries, defaults is the only target.
+func ParseRegionTargets(entries []string, defaults RegionTarget) ([]RegionTarget, error) {
+	if len(entries) == 0 {
+		return []RegionTarget{defaults}, nil
+	}
+
+	var targets []RegionTarget
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		target := defaults
+		target.Region = fields[0]
+		if seen[target.Region] {
+			return nil, fmt.Errorf("region %s is listed more than once", target.Region)
+		}
+		seen[target.Region] = true
+
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid setting %q for region %s, expected key=value", field, target.Region)
+			}
+			switch key {
+			case "ec2-image-id":
+				target.ImageId = value
+			case "subnet-id":
+				target.SubnetId = value
+			case "security-group-id":
+				target.SecurityGroupId = value
+			default:
+				return nil, fmt.Errorf("unknown setting %q for region %s, supported settings are ec2-image-id, subnet-id and security-group-id", key, target.Region)
+			}
+		}
+		if target.ImageId == "" || target.SubnetId == "" || target.SecurityGroupId == "" {
+			return nil, fmt.Errorf("region %s needs ec2-image-id, subnet-id and security-group-id", target.Region)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// StartInRegions calls start for each target in turn until an instance is launched. If the launch fails for lack
+// of capacity or quota in a region, before any instance was launched, the next region is tried. It returns the
+// target start was last called for, with its instance ID and error.
+func StartInRegions(ctx context.Context, logger Logger, targets []RegionTarget, start func(RegionTarget) (string, error)) (RegionTarget, string, error) {
+	for i, target := range targets {
+		if len(targets) > 1 {
+			logger.Infof("Launching instance in region %s", target.Region)
+		}
+		instanceId, err := start(target)
+		if err == nil || instanceId != "" || !isCapacityError(err) || i == len(targets)-1 || ctx.Err() != nil {
+			return target, instanceId, err
+		}
+		logger.Warningf("Could not launch instance in region %s (%s), trying region %s", target.Region, errorCode(err), targets[i+1].Region)
+	}
+	return RegionTarget{}, "", fmt.Errorf("no regions to launch an instance in")
+}
+
+// isCapacityError returns whether err is due to a lack of capacity or an exhausted quota in a region.
+func isCapacityError(err error) bool {
+	for _, code := range capacityErrorCodes {
+		if isErrorCode(err, code) {
+			return true
+		}
+	}
+	return false
+}
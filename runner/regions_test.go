@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/sethvargo/go-githubactions"
+)
+
+func TestParseRegionTargets(t *testing.T) {
+	defaults := RegionTarget{Region: "us-east-1", ImageId: "ami-default", SubnetId: "subnet-default", SecurityGroupId: "sg-default"}
+
+	targets, err := ParseRegionTargets(nil, defaults)
+	if err != nil || !reflect.DeepEqual(targets, []RegionTarget{defaults}) {
+		t.Fatalf("expected only the default target, got %v, %v", targets, err)
+	}
+
+	targets, err = ParseRegionTargets([]string{
+		"us-east-1",
+		"us-west-2 ec2-image-id=ami-west subnet-id=subnet-west security-group-id=sg-west",
+	}, defaults)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := []RegionTarget{
+		{Region: "us-east-1", ImageId: "ami-default", SubnetId: "subnet-default", SecurityGroupId: "sg-default"},
+		{Region: "us-west-2", ImageId: "ami-west", SubnetId: "subnet-west", SecurityGroupId: "sg-west"},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Fatalf("expected %v, got %v", want, targets)
+	}
+
+	for _, entries := range [][]string{
+		{"us-east-1", "us-east-1"},
+		{"us-west-2 ec2-image-id"},
+		{"us-west-2 instance-type=t3.micro"},
+	} {
+		if _, err := ParseRegionTargets(entries, defaults); err == nil {
+			t.Fatalf("expected error for %v", entries)
+		}
+	}
+
+	if _, err := ParseRegionTargets([]string{"us-west-2 subnet-id=subnet-west"}, RegionTarget{}); err == nil {
+		t.Fatalf("expected error for region without an AMI and security group")
+	}
+}
+
+func TestStartInRegions(t *testing.T) {
+	action := githubactions.New()
+	targets := []RegionTarget{{Region: "us-east-1"}, {Region: "us-east-2"}, {Region: "us-west-2"}}
+
+	ctx := context.Background()
+
+	var tried []string
+	target, instanceId, err := StartInRegions(ctx, action, targets, func(target RegionTarget) (string, error) {
+		tried = append(tried, target.Region)
+		if target.Region == "us-east-1" {
+			return "", fmt.Errorf("error starting EC2 instance: %w", &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"})
+		}
+		if target.Region == "us-east-2" {
+			return "", &smithy.GenericAPIError{Code: "VcpuLimitExceeded"}
+		}
+		return "i-1234567890abcdef0", nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if target.Region != "us-west-2" || instanceId != "i-1234567890abcdef0" {
+		t.Fatalf("expected instance in us-west-2, got %s in %s", instanceId, target.Region)
+	}
+	if len(tried) != 3 {
+		t.Fatalf("expected 3 regions to be tried, got %v", tried)
+	}
+}
+
+func TestStartInRegionsOtherError(t *testing.T) {
+	action := githubactions.New()
+	targets := []RegionTarget{{Region: "us-east-1"}, {Region: "us-west-2"}}
+
+	ctx := context.Background()
+
+	var tried []string
+	target, _, err := StartInRegions(ctx, action, targets, func(target RegionTarget) (string, error) {
+		tried = append(tried, target.Region)
+		return "", &smithy.GenericAPIError{Code: "InvalidAMIID.NotFound"}
+	})
+	if err == nil || target.Region != "us-east-1" || len(tried) != 1 {
+		t.Fatalf("expected failure in us-east-1 only, got %v after trying %v", err, tried)
+	}
+
+	// An instance that was launched but isn't ready is not retried elsewhere.
+	tried = nil
+	_, instanceId, err := StartInRegions(ctx, action, targets, func(target RegionTarget) (string, error) {
+		tried = append(tried, target.Region)
+		return "i-1234567890abcdef0", &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"}
+	})
+	if err == nil || instanceId == "" || len(tried) != 1 {
+		t.Fatalf("expected launched instance to be returned, got %q, %v after trying %v", instanceId, err, tried)
+	}
+}
@@ -0,0 +1,372 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+// RetryPolicy controls how AWS API calls that fail with throttling or other transient errors are retried.
+// Delays grow exponentially from BaseDelay up to MaxDelay, and Jitter is the fraction (0 to 1) of each
+// delay that is randomised so that many jobs throttled at once don't retry in lockstep.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// DefaultRetryPolicy is the retry policy used unless it is configured through inputs.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    20 * time.Second,
+	Jitter:      0.5,
+}
+
+// retryableErrors classifies errors using the AWS SDK's default rules, which cover throttling error codes
+// such as RequestLimitExceeded and ThrottlingException, 5xx responses and connection errors.
+var retryableErrors = retry.IsErrorRetryables(retry.DefaultRetryables)
+
+// IsRetryableError reports whether an AWS API call that failed with err should be retried.
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return retryableErrors.IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// Delay returns how long to wait before the given retry attempt, where attempt 1 is the first retry.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	return time.Duration(delay*(1-p.Jitter) + rand.Float64()*delay*p.Jitter)
+}
+
+// retryCall calls fn until it succeeds, fails with an error that isn't retryable, MaxAttempts is reached or
+// ctx is done. Each retry is logged with the name of the operation, and an AWS API error it finally fails with
+// is returned as an AWSError.
+func retryCall[T any](ctx context.Context, logger Logger, policy RetryPolicy, operation string, fn func() (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil {
+			return result, nil
+		}
+		if attempt >= policy.MaxAttempts || !IsRetryableError(err) {
+			return result, NewAWSError(operation, err)
+		}
+
+		delay := policy.Delay(attempt)
+		logger.Infof("%s failed (%s), retrying in %s (attempt %d of %d)", operation, errorCode(err), delay.Round(time.Millisecond), attempt+1, policy.MaxAttempts)
+		if serr := sleepContext(ctx, delay); serr != nil {
+			return result, NewAWSError(operation, err)
+		}
+	}
+}
+
+// errorCode returns the AWS error code of err, or its message if it isn't an AWS API error.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return err.Error()
+}
+
+// retryingEC2Client retries the calls of an EC2API according to a RetryPolicy.
+type retryingEC2Client struct {
+	client EC2API
+	policy RetryPolicy
+	logger Logger
+}
+
+// NewRetryingEC2Client returns an EC2API that retries throttled and transient failures of client's calls.
+func NewRetryingEC2Client(logger Logger, client EC2API, policy RetryPolicy) EC2API {
+	return &retryingEC2Client{client: client, policy: policy, logger: logger}
+}
+
+func (c *retryingEC2Client) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:RunInstances", func() (*ec2.RunInstancesOutput, error) {
+		return c.client.RunInstances(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:DescribeInstances", func() (*ec2.DescribeInstancesOutput, error) {
+		return c.client.DescribeInstances(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) DescribeInstanceStatus(ctx context.Context, params *ec2.DescribeInstanceStatusInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:DescribeInstanceStatus", func() (*ec2.DescribeInstanceStatusOutput, error) {
+		return c.client.DescribeInstanceStatus(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) TerminateInstances(ctx context.Context, params *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:TerminateInstances", func() (*ec2.TerminateInstancesOutput, error) {
+		return c.client.TerminateInstances(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:GetConsoleOutput", func() (*ec2.GetConsoleOutputOutput, error) {
+		return c.client.GetConsoleOutput(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) GetConsoleScreenshot(ctx context.Context, params *ec2.GetConsoleScreenshotInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleScreenshotOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:GetConsoleScreenshot", func() (*ec2.GetConsoleScreenshotOutput, error) {
+		return c.client.GetConsoleScreenshot(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:DescribeImages", func() (*ec2.DescribeImagesOutput, error) {
+		return c.client.DescribeImages(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:DescribeSubnets", func() (*ec2.DescribeSubnetsOutput, error) {
+		return c.client.DescribeSubnets(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:DescribeSecurityGroups", func() (*ec2.DescribeSecurityGroupsOutput, error) {
+		return c.client.DescribeSecurityGroups(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) DescribeInstanceTypeOfferings(ctx context.Context, params *ec2.DescribeInstanceTypeOfferingsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:DescribeInstanceTypeOfferings", func() (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+		return c.client.DescribeInstanceTypeOfferings(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingEC2Client) DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ec2:DescribeInstanceTypes", func() (*ec2.DescribeInstanceTypesOutput, error) {
+		return c.client.DescribeInstanceTypes(ctx, params, optFns...)
+	})
+}
+
+// retryingSSMClient retries the calls of an SSMAPI according to a RetryPolicy.
+type retryingSSMClient struct {
+	client SSMAPI
+	policy RetryPolicy
+	logger Logger
+}
+
+// NewRetryingSSMClient returns an SSMAPI that retries throttled and transient failures of client's calls.
+func NewRetryingSSMClient(logger Logger, client SSMAPI, policy RetryPolicy) SSMAPI {
+	return &retryingSSMClient{client: client, policy: policy, logger: logger}
+}
+
+func (c *retryingSSMClient) SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ssm:SendCommand", func() (*ssm.SendCommandOutput, error) {
+		return c.client.SendCommand(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingSSMClient) GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ssm:GetCommandInvocation", func() (*ssm.GetCommandInvocationOutput, error) {
+		return c.client.GetCommandInvocation(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingSSMClient) CancelCommand(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ssm:CancelCommand", func() (*ssm.CancelCommandOutput, error) {
+		return c.client.CancelCommand(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingSSMClient) DescribeInstanceInformation(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ssm:DescribeInstanceInformation", func() (*ssm.DescribeInstanceInformationOutput, error) {
+		return c.client.DescribeInstanceInformation(ctx, params, optFns...)
+	})
+}
+
+// retryingSSMParameterClient retries the calls of an SSMParameterAPI according to a RetryPolicy.
+type retryingSSMParameterClient struct {
+	client SSMParameterAPI
+	policy RetryPolicy
+	logger Logger
+}
+
+// NewRetryingSSMParameterClient returns an SSMParameterAPI that retries throttled and transient failures of
+// client's calls.
+func NewRetryingSSMParameterClient(logger Logger, client SSMParameterAPI, policy RetryPolicy) SSMParameterAPI {
+	return &retryingSSMParameterClient{client: client, policy: policy, logger: logger}
+}
+
+func (c *retryingSSMParameterClient) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "ssm:GetParameter", func() (*ssm.GetParameterOutput, error) {
+		return c.client.GetParameter(ctx, params, optFns...)
+	})
+}
+
+// retryingIAMClient retries the calls of an IAMAPI according to a RetryPolicy.
+type retryingIAMClient struct {
+	client IAMAPI
+	policy RetryPolicy
+	logger Logger
+}
+
+// NewRetryingIAMClient returns an IAMAPI that retries throttled and transient failures of client's calls.
+func NewRetryingIAMClient(logger Logger, client IAMAPI, policy RetryPolicy) IAMAPI {
+	return &retryingIAMClient{client: client, policy: policy, logger: logger}
+}
+
+func (c *retryingIAMClient) CreateInstanceProfile(ctx context.Context, params *iam.CreateInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.CreateInstanceProfileOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:CreateInstanceProfile", func() (*iam.CreateInstanceProfileOutput, error) {
+		return c.client.CreateInstanceProfile(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingIAMClient) AddRoleToInstanceProfile(ctx context.Context, params *iam.AddRoleToInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.AddRoleToInstanceProfileOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:AddRoleToInstanceProfile", func() (*iam.AddRoleToInstanceProfileOutput, error) {
+		return c.client.AddRoleToInstanceProfile(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingIAMClient) ListInstanceProfilesForRole(ctx context.Context, params *iam.ListInstanceProfilesForRoleInput, optFns ...func(*iam.Options)) (*iam.ListInstanceProfilesForRoleOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:ListInstanceProfilesForRole", func() (*iam.ListInstanceProfilesForRoleOutput, error) {
+		return c.client.ListInstanceProfilesForRole(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingIAMClient) GetInstanceProfile(ctx context.Context, params *iam.GetInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.GetInstanceProfileOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:GetInstanceProfile", func() (*iam.GetInstanceProfileOutput, error) {
+		return c.client.GetInstanceProfile(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingIAMClient) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:GetRole", func() (*iam.GetRoleOutput, error) {
+		return c.client.GetRole(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingIAMClient) CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:CreateRole", func() (*iam.CreateRoleOutput, error) {
+		return c.client.CreateRole(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingIAMClient) AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:AttachRolePolicy", func() (*iam.AttachRolePolicyOutput, error) {
+		return c.client.AttachRolePolicy(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingIAMClient) PutRolePolicy(ctx context.Context, params *iam.PutRolePolicyInput, optFns ...func(*iam.Options)) (*iam.PutRolePolicyOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:PutRolePolicy", func() (*iam.PutRolePolicyOutput, error) {
+		return c.client.PutRolePolicy(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingIAMClient) SimulatePrincipalPolicy(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "iam:SimulatePrincipalPolicy", func() (*iam.SimulatePrincipalPolicyOutput, error) {
+		return c.client.SimulatePrincipalPolicy(ctx, params, optFns...)
+	})
+}
+
+// retryingS3Client retries the calls of an S3API according to a RetryPolicy.
+type retryingS3Client struct {
+	client S3API
+	policy RetryPolicy
+	logger Logger
+}
+
+// NewRetryingS3Client returns an S3API that retries throttled and transient failures of client's calls. An object
+// is only uploaded again if its body can be rewound with io.Seeker.
+func NewRetryingS3Client(logger Logger, client S3API, policy RetryPolicy) S3API {
+	return &retryingS3Client{client: client, policy: policy, logger: logger}
+}
+
+func (c *retryingS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	policy := c.policy
+	body, seekable := params.Body.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = body.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+	if params.Body != nil && !seekable {
+		policy.MaxAttempts = 1
+	}
+	attempt := 0
+	return retryCall(ctx, c.logger, policy, "s3:PutObject", func() (*s3.PutObjectOutput, error) {
+		if attempt++; attempt > 1 && seekable {
+			if _, err := body.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		return c.client.PutObject(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "s3:GetObject", func() (*s3.GetObjectOutput, error) {
+		return c.client.GetObject(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "s3:DeleteObject", func() (*s3.DeleteObjectOutput, error) {
+		return c.client.DeleteObject(ctx, params, optFns...)
+	})
+}
+
+// retryingSTSClient retries the calls of an STSAPI according to a RetryPolicy.
+type retryingSTSClient struct {
+	client STSAPI
+	policy RetryPolicy
+	logger Logger
+}
+
+// NewRetryingSTSClient returns an STSAPI that retries throttled and transient failures of client's calls.
+func NewRetryingSTSClient(logger Logger, client STSAPI, policy RetryPolicy) STSAPI {
+	return &retryingSTSClient{client: client, policy: policy, logger: logger}
+}
+
+func (c *retryingSTSClient) AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "sts:AssumeRoleWithWebIdentity", func() (*sts.AssumeRoleWithWebIdentityOutput, error) {
+		return c.client.AssumeRoleWithWebIdentity(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingSTSClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "sts:AssumeRole", func() (*sts.AssumeRoleOutput, error) {
+		return c.client.AssumeRole(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingSTSClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "sts:GetCallerIdentity", func() (*sts.GetCallerIdentityOutput, error) {
+		return c.client.GetCallerIdentity(ctx, params, optFns...)
+	})
+}
+
+func (c *retryingSTSClient) DecodeAuthorizationMessage(ctx context.Context, params *sts.DecodeAuthorizationMessageInput, optFns ...func(*sts.Options)) (*sts.DecodeAuthorizationMessageOutput, error) {
+	return retryCall(ctx, c.logger, c.policy, "sts:DecodeAuthorizationMessage", func() (*sts.DecodeAuthorizationMessageOutput, error) {
+		return c.client.DecodeAuthorizationMessage(ctx, params, optFns...)
+	})
+}
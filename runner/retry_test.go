@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/smithy-go"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// FlakyEC2Client fails the first failures DescribeInstances calls with err.
+type FlakyEC2Client struct {
+	MockEC2Client
+	failures int
+	err      error
+	calls    int
+}
+
+func (m *FlakyEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	m.calls++
+	if m.calls <= m.failures {
+		return nil, m.err
+	}
+	return m.MockEC2Client.DescribeInstances(ctx, params, optFns...)
+}
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestRetryingEC2ClientThrottled(t *testing.T) {
+	action := githubactions.New()
+	mockEC2 := &FlakyEC2Client{failures: 3, err: &smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "Request limit exceeded."}}
+	client := NewRetryingEC2Client(action, mockEC2, testRetryPolicy)
+
+	ctx := context.Background()
+
+	if _, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{}); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if mockEC2.calls != 4 {
+		t.Fatalf("expected 4 calls, got %d", mockEC2.calls)
+	}
+}
+
+func TestRetryingEC2ClientMaxAttempts(t *testing.T) {
+	action := githubactions.New()
+	mockEC2 := &FlakyEC2Client{failures: 10, err: &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}}
+	client := NewRetryingEC2Client(action, mockEC2, testRetryPolicy)
+
+	ctx := context.Background()
+
+	_, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{})
+	if !isErrorCode(err, "ThrottlingException") {
+		t.Fatalf("expected ThrottlingException after max attempts, got %v", err)
+	}
+	if mockEC2.calls != testRetryPolicy.MaxAttempts {
+		t.Fatalf("expected %d calls, got %d", testRetryPolicy.MaxAttempts, mockEC2.calls)
+	}
+}
+
+func TestRetryingEC2ClientNotRetryable(t *testing.T) {
+	action := githubactions.New()
+	mockEC2 := &FlakyEC2Client{failures: 1, err: &smithy.GenericAPIError{Code: "InvalidAMIID.NotFound", Message: "The image id does not exist"}}
+	client := NewRetryingEC2Client(action, mockEC2, testRetryPolicy)
+
+	ctx := context.Background()
+
+	if _, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if mockEC2.calls != 1 {
+		t.Fatalf("expected 1 call, got %d", mockEC2.calls)
+	}
+}
+
+// FlakyS3Client reads the body of the first failures PutObject calls and then fails them with a throttling error.
+type FlakyS3Client struct {
+	*MockS3Client
+	failures int
+	calls    int
+}
+
+func (m *FlakyS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	m.calls++
+	if m.calls <= m.failures {
+		io.Copy(io.Discard, params.Body)
+		return nil, &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}
+	}
+	return m.MockS3Client.PutObject(ctx, params, optFns...)
+}
+
+func TestRetryingS3ClientPutObject(t *testing.T) {
+	tests := []struct {
+		name string
+		body io.Reader
+		// calls is the number of PutObject calls expected, and uploaded whether the object is expected to be put.
+		calls    int
+		uploaded bool
+	}{
+		{"rewound", strings.NewReader("archive"), 3, true},
+		{"not seekable", struct{ io.Reader }{strings.NewReader("archive")}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockS3 := &FlakyS3Client{MockS3Client: NewMockS3Client(), failures: 2}
+			client := NewRetryingS3Client(githubactions.New(), mockS3, testRetryPolicy)
+
+			_, err := client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key"), Body: tt.body})
+			if (err == nil) != tt.uploaded {
+				t.Fatalf("expected the object uploaded to be %v, got %v", tt.uploaded, err)
+			}
+			if mockS3.calls != tt.calls {
+				t.Fatalf("expected %d calls, got %d", tt.calls, mockS3.calls)
+			}
+			if tt.uploaded && string(mockS3.objects["bucket/key"]) != "archive" {
+				t.Fatalf("expected the whole body to be uploaded, got %q", mockS3.objects["bucket/key"])
+			}
+		})
+	}
+}
+
+// FlakySSMParameterClient fails the first failures GetParameter calls with err.
+type FlakySSMParameterClient struct {
+	MockSSMParameterClient
+	failures int
+	err      error
+	calls    int
+}
+
+func (m *FlakySSMParameterClient) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	m.calls++
+	if m.calls <= m.failures {
+		return nil, m.err
+	}
+	return m.MockSSMParameterClient.GetParameter(ctx, params, optFns...)
+}
+
+func TestRetryingSSMParameterClientGetParameter(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		// calls is the number of GetParameter calls expected, and code the AWSError code expected, if any.
+		calls int
+		code  string
+	}{
+		{"throttled", 2, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}, 3, ""},
+		{"not found", 1, &smithy.GenericAPIError{Code: "ParameterNotFound"}, 1, "ParameterNotFound"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSSM := &FlakySSMParameterClient{
+				MockSSMParameterClient: MockSSMParameterClient{parameters: map[string]string{"/runner/profiles": "profiles: {}"}},
+				failures:               tt.failures,
+				err:                    tt.err,
+			}
+			client := NewRetryingSSMParameterClient(githubactions.New(), mockSSM, testRetryPolicy)
+
+			_, err := client.GetParameter(context.Background(), &ssm.GetParameterInput{Name: aws.String("/runner/profiles")})
+			if tt.code == "" && err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if tt.code != "" {
+				var awsErr *AWSError
+				if !errors.As(err, &awsErr) || awsErr.Operation != "ssm:GetParameter" || awsErr.Code != tt.code {
+					t.Fatalf("expected an ssm:GetParameter AWSError with code %s, got %v", tt.code, err)
+				}
+			}
+			if mockSSM.calls != tt.calls {
+				t.Fatalf("expected %d calls, got %d", tt.calls, mockSSM.calls)
+			}
+		})
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	if IsRetryableError(context.Canceled) {
+		t.Fatalf("expected context cancellation not to be retryable")
+	}
+	if IsRetryableError(errors.New("boom")) {
+		t.Fatalf("expected unknown error not to be retryable")
+	}
+	if !IsRetryableError(&smithy.GenericAPIError{Code: "RequestLimitExceeded"}) {
+		t.Fatalf("expected RequestLimitExceeded to be retryable")
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 0.5}
+
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second} {
+		delay := policy.Delay(attempt)
+		if delay < max/2 || delay > max {
+			t.Fatalf("expected delay for attempt %d between %s and %s, got %s", attempt, max/2, max, delay)
+		}
+	}
+}
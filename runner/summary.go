@@ -0,0 +1,226 @@
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// pricingRegion is the region whose prices are in largeHourlyPrices.
+const pricingRegion = "us-east-1"
+
+// largeHourlyPrices are the on-demand prices in USD per hour of the large size of common instance families, for
+// Linux in pricingRegion. Other sizes of a family are priced in proportion to their vCPUs, as AWS prices them.
+var largeHourlyPrices = map[string]float64{
+	"t3": 0.0832, "t3a": 0.0752, "t4g": 0.0672,
+	"m5": 0.096, "m5a": 0.086, "m6i": 0.096, "m6a": 0.0864, "m6g": 0.077, "m7i": 0.1008, "m7a": 0.11592, "m7g": 0.0816,
+	"c5": 0.085, "c5a": 0.077, "c6i": 0.085, "c6a": 0.0765, "c6g": 0.068, "c7i": 0.08925, "c7a": 0.10264, "c7g": 0.0725,
+	"r5": 0.126, "r5a": 0.113, "r6i": 0.126, "r6a": 0.1134, "r6g": 0.1008, "r7i": 0.1323, "r7g": 0.1071,
+}
+
+// sizeMultipliers are the sizes below large and their price relative to it. Sizes above large are a number of
+// xlarges, e.g. 4xlarge is 8 times large.
+var sizeMultipliers = map[string]float64{"nano": 1.0 / 16, "micro": 1.0 / 8, "small": 1.0 / 4, "medium": 1.0 / 2, "large": 1, "xlarge": 2}
+
+// minimumBilledDuration is the shortest time an on-demand Linux instance is billed for.
+const minimumBilledDuration = time.Minute
+
+// hourlyPrice returns the estimated on-demand price in USD per hour of an instance type, and false if it isn't known.
+func hourlyPrice(instanceType string) (float64, bool) {
+	family, size, ok := strings.Cut(instanceType, ".")
+	if !ok {
+		return 0, false
+	}
+	price, ok := largeHourlyPrices[family]
+	if !ok {
+		return 0, false
+	}
+	if multiplier, ok := sizeMultipliers[size]; ok {
+		return price * multiplier, true
+	}
+	xlarges, err := strconv.Atoi(strings.TrimSuffix(size, "xlarge"))
+	if err != nil || !strings.HasSuffix(size, "xlarge") || xlarges <= 0 {
+		return 0, false
+	}
+	return price * 2 * float64(xlarges), true
+}
+
+// marketType returns how an instance is billed: on-demand, spot, scheduled or capacity-block.
+func marketType(instance ec2Types.Instance) string {
+	if instance.InstanceLifecycle == "" {
+		return "on-demand"
+	}
+	return string(instance.InstanceLifecycle)
+}
+
+// estimatedCost describes the estimated cost of running an instance in region for duration, or its hourly price if
+// duration is zero, and returns false if it can't be estimated. The price is hourlyPriceOverride if it is set, and
+// otherwise the built-in price, which is only known for on-demand instances in pricingRegion.
+func estimatedCost(instance ec2Types.Instance, region string, hourlyPriceOverride float64, duration time.Duration) (string, bool) {
+	onDemand := marketType(instance) == "on-demand"
+	price, basis := hourlyPriceOverride, "hourly-price"
+	if price == 0 {
+		if !onDemand || region != pricingRegion {
+			return "", false
+		}
+		var ok bool
+		if price, ok = hourlyPrice(string(instance.InstanceType)); !ok {
+			return "", false
+		}
+		basis = "on-demand price in " + pricingRegion
+	}
+	if duration == 0 {
+		return fmt.Sprintf("$%.4f per hour (%s)", price, basis), true
+	}
+	if onDemand {
+		duration = max(duration, minimumBilledDuration)
+	}
+	return fmt.Sprintf("$%.4f at $%.4f per hour (%s)", price*duration.Hours(), price, basis), true
+}
+
+// summaryTable renders a Markdown table with a header row.
+func summaryTable(header []string, rows [][]string) string {
+	var b strings.Builder
+	b.WriteString("| " + strings.Join(header, " | ") + " |\n")
+	b.WriteString(strings.Repeat("|---", len(header)) + "|\n")
+	for _, row := range rows {
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+	}
+	return b.String()
+}
+
+// instanceRows returns the details of an instance as rows of a summary table.
+func instanceRows(instance ec2Types.Instance, region string) [][]string {
+	var az string
+	if instance.Placement != nil {
+		az = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+	rows := [][]string{
+		{"Instance ID", "`" + aws.ToString(instance.InstanceId) + "`"},
+		{"Instance type", string(instance.InstanceType)},
+	}
+	if region != "" {
+		rows = append(rows, []string{"Region", region})
+	}
+	return append(rows,
+		[]string{"Availability zone", az},
+		[]string{"AMI", "`" + aws.ToString(instance.ImageId) + "`"},
+		[]string{"Market type", marketType(instance)},
+	)
+}
+
+// formatSummaryTime formats a time in a summary, or returns "-" for the zero time.
+func formatSummaryTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// startSummary returns the step summary of start: the instance launched in region, when it was launched and ready,
+// how long each readiness condition took and its estimated hourly cost, if it can be estimated.
+func startSummary(result *StartResult, region string, hourlyPriceOverride float64) string {
+	rows := instanceRows(result.Instance, region)
+	rows = append(rows,
+		[]string{"Launched", formatSummaryTime(result.LaunchedAt)},
+		[]string{"Ready", formatSummaryTime(result.ReadyAt)},
+	)
+	if cost, ok := estimatedCost(result.Instance, region, hourlyPriceOverride, 0); ok {
+		rows = append(rows, []string{"Estimated cost", cost})
+	}
+	summary := "### EC2 runner started\n\n" + summaryTable([]string{"", ""}, rows)
+	if len(result.Phases) > 0 {
+		var phases [][]string
+		for _, phase := range result.Phases {
+			phases = append(phases, []string{phase.Condition, phase.Duration.Round(time.Second).String()})
+		}
+		summary += "\n" + summaryTable([]string{"Boot phase", "Duration"}, phases)
+	}
+	return summary
+}
+
+// commandSummaryRow is a command in the step summary of a mode that runs commands.
+type commandSummaryRow struct {
+	CommandId CommandId
+	Status    string
+	// ExitCode is the exit code of the command, or -1 if it isn't known.
+	ExitCode int
+	// Started and Ended are when the command was sent and found to have finished, if known.
+	Started, Ended string
+}
+
+// commandSummary returns the step summary of a mode that runs commands on an instance.
+func commandSummary(mode, instanceId string, commands []commandSummaryRow) string {
+	var rows [][]string
+	for _, command := range commands {
+		exitCode := "-"
+		if command.ExitCode >= 0 {
+			exitCode = strconv.Itoa(command.ExitCode)
+		}
+		rows = append(rows, []string{"`" + string(command.CommandId) + "`", command.Status, exitCode, orDash(command.Started), orDash(command.Ended)})
+	}
+	return fmt.Sprintf("### EC2 runner %s on `%s`\n\n", mode, instanceId) +
+		summaryTable([]string{"Command ID", "Status", "Exit code", "Started", "Ended"}, rows)
+}
+
+// stopSummary returns the step summary of stop: the instance terminated in region, how long it ran since its launch
+// time and its estimated cost, if it can be estimated.
+func stopSummary(instance ec2Types.Instance, region string, hourlyPriceOverride float64, terminatedAt time.Time) string {
+	launchedAt := aws.ToTime(instance.LaunchTime)
+	rows := instanceRows(instance, "")
+	rows = append(rows,
+		[]string{"Launched", formatSummaryTime(launchedAt)},
+		[]string{"Terminated", formatSummaryTime(terminatedAt)},
+	)
+	var duration time.Duration
+	if !launchedAt.IsZero() && terminatedAt.After(launchedAt) {
+		duration = terminatedAt.Sub(launchedAt)
+		rows = append(rows, []string{"Duration", duration.Round(time.Second).String()})
+		if cost, ok := estimatedCost(instance, region, hourlyPriceOverride, duration); ok {
+			rows = append(rows, []string{"Estimated cost", cost})
+		}
+	}
+	return "### EC2 runner stopped\n\n" + summaryTable([]string{"", ""}, rows)
+}
+
+// orDash returns s, or "-" if it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// addStepSummary appends markdown to the step summary, if the runner has one.
+func addStepSummary(reporter Reporter, markdown string) {
+	if reporter.Getenv("GITHUB_STEP_SUMMARY") == "" {
+		return
+	}
+	reporter.AddStepSummary(markdown)
+}
+
+// resultSummaryRow returns the summary row of a finished command, with the times it was sent, if known, and
+// found to have finished.
+func resultSummaryRow(result *CommandResult) commandSummaryRow {
+	return commandSummaryRow{
+		CommandId: result.CommandId,
+		Status:    string(result.Status),
+		ExitCode:  int(result.ExitCode),
+		Started:   formatSummaryTime(result.SentAt),
+		Ended:     formatSummaryTime(result.FinishedAt),
+	}
+}
+
+// copySummaryRow returns the summary row of the command that copies files, which failed if err isn't nil.
+func copySummaryRow(commandId CommandId, err error) commandSummaryRow {
+	status := string(ssmTypes.CommandInvocationStatusSuccess)
+	if err != nil {
+		status = string(ssmTypes.CommandInvocationStatusFailed)
+	}
+	return commandSummaryRow{CommandId: commandId, Status: status, ExitCode: -1}
+}
@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/sethvargo/go-githubactions"
+)
+
+func TestHourlyPrice(t *testing.T) {
+	for instanceType, want := range map[string]float64{
+		"t3.micro":    0.0104,
+		"t3.large":    0.0832,
+		"m5.xlarge":   0.192,
+		"c6i.4xlarge": 0.68,
+	} {
+		if price, ok := hourlyPrice(instanceType); !ok || price < want-0.00001 || price > want+0.00001 {
+			t.Fatalf("expected %s to cost %v per hour, got %v, %v", instanceType, want, price, ok)
+		}
+	}
+	for _, instanceType := range []string{"x2iedn.metal", "t3", "m5.metal", "p5.48xlarge"} {
+		if _, ok := hourlyPrice(instanceType); ok {
+			t.Fatalf("expected no price for %s", instanceType)
+		}
+	}
+}
+
+func TestStartSummary(t *testing.T) {
+	launchedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	result := &StartResult{
+		InstanceId: testEC2ClientId,
+		Instance: ec2Types.Instance{
+			InstanceId:        aws.String(testEC2ClientId),
+			InstanceType:      ec2Types.InstanceTypeT3Micro,
+			ImageId:           aws.String("ami-12345678"),
+			Placement:         &ec2Types.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+			InstanceLifecycle: ec2Types.InstanceLifecycleTypeSpot,
+		},
+		LaunchedAt: launchedAt,
+		ReadyAt:    launchedAt.Add(95 * time.Second),
+		Phases:     []ReadinessPhase{{Condition: ReadyRunning, Duration: 20 * time.Second}, {Condition: ReadySSMOnline, Duration: 75 * time.Second}},
+	}
+
+	summary := startSummary(result, "eu-west-1", 0)
+	for _, want := range []string{
+		"| Instance ID | `" + testEC2ClientId + "` |",
+		"| Availability zone | eu-west-1a |",
+		"| AMI | `ami-12345678` |",
+		"| Market type | spot |",
+		"| Ready | 2024-06-01T12:01:35Z |",
+		"| ssm-online | 1m15s |",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Fatalf("expected %q in the summary, got:\n%s", want, summary)
+		}
+	}
+	// The built-in prices are only for on-demand instances in us-east-1.
+	if strings.Contains(summary, "Estimated cost") {
+		t.Fatalf("expected no estimated cost of a spot instance, got:\n%s", summary)
+	}
+
+	if summary := startSummary(result, "eu-west-1", 0.004); !strings.Contains(summary, "| Estimated cost | $0.0040 per hour (hourly-price) |") {
+		t.Fatalf("expected the cost at hourly-price, got:\n%s", summary)
+	}
+}
+
+func TestCommandSummary(t *testing.T) {
+	summary := commandSummary("command", testEC2ClientId, []commandSummaryRow{
+		{CommandId: "cmd-1", Status: "Failed", ExitCode: 2, Started: "2024-06-01T12:00:00Z", Ended: "2024-06-01T12:00:05Z"},
+		{CommandId: "cmd-2", Status: "Sent", ExitCode: -1},
+	})
+	for _, want := range []string{
+		"| `cmd-1` | Failed | 2 | 2024-06-01T12:00:00Z | 2024-06-01T12:00:05Z |",
+		"| `cmd-2` | Sent | - | - | - |",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Fatalf("expected %q in the summary, got:\n%s", want, summary)
+		}
+	}
+}
+
+func TestStopSummary(t *testing.T) {
+	launchedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	instance := ec2Types.Instance{InstanceId: aws.String(testEC2ClientId), InstanceType: ec2Types.InstanceTypeT3Large, LaunchTime: aws.Time(launchedAt)}
+
+	summary := stopSummary(instance, "us-east-1", 0, launchedAt.Add(30*time.Minute))
+	for _, want := range []string{"| Duration | 30m0s |", "| Estimated cost | $0.0416 at $0.0832 per hour (on-demand price in us-east-1) |", "| Market type | on-demand |"} {
+		if !strings.Contains(summary, want) {
+			t.Fatalf("expected %q in the summary, got:\n%s", want, summary)
+		}
+	}
+
+	// Instances are billed for at least a minute.
+	if summary := stopSummary(instance, "us-east-1", 0, launchedAt.Add(10*time.Second)); !strings.Contains(summary, "$0.0014 at") {
+		t.Fatalf("expected the minimum billed duration, got:\n%s", summary)
+	}
+
+	if summary := stopSummary(instance, "eu-west-1", 0, launchedAt.Add(30*time.Minute)); strings.Contains(summary, "Estimated cost") {
+		t.Fatalf("expected no estimated cost outside us-east-1, got:\n%s", summary)
+	}
+
+	// The minimum billed duration is only for on-demand instances.
+	instance.InstanceLifecycle = ec2Types.InstanceLifecycleTypeSpot
+	if summary := stopSummary(instance, "eu-west-1", 0.036, launchedAt.Add(10*time.Second)); !strings.Contains(summary, "| Estimated cost | $0.0001 at $0.0360 per hour (hourly-price) |") {
+		t.Fatalf("expected the cost at hourly-price, got:\n%s", summary)
+	}
+}
+
+func TestAddStepSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.md")
+	action := githubactions.New(githubactions.WithGetenv(func(key string) string {
+		if key == "GITHUB_STEP_SUMMARY" {
+			return path
+		}
+		return ""
+	}))
+	addStepSummary(action, "### EC2 runner stopped")
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "### EC2 runner stopped") {
+		t.Fatalf("expected the summary to be written, got %q, %v", data, err)
+	}
+
+	// Without a step summary file, e.g. outside GitHub Actions, nothing is written.
+	addStepSummary(githubactions.New(githubactions.WithGetenv(func(string) string { return "" })), "### EC2 runner stopped")
+}
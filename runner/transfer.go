@@ -0,0 +1,352 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// copyToInstanceScript downloads the staged archive on the instance, verifies its checksum and extracts it.
+// The arguments are the target directory, the presigned download URL and the expected SHA-256 checksum.
+const copyToInstanceScript = `set -e
+target=%s
+mkdir -p "$target"
+archive=$(mktemp)
+trap 'rm -f "$archive"' EXIT
+curl -sSf -o "$archive" %s
+echo "%s  $archive" | sha256sum -c --status - || { echo "checksum mismatch for downloaded archive" >&2; exit 1; }
+tar -xzf "$archive" -C "$target"
+echo "Extracted archive to $target"`
+
+// copyFromInstanceScript archives the requested paths on the instance, uploads the archive and prints its checksum
+// as the last line of output. The arguments are the required paths, the optional paths and the presigned upload URL.
+// Each path is archived under its base name.
+const copyFromInstanceScript = `set -e
+archive=$(mktemp)
+trap 'rm -f "$archive"' EXIT
+missing=0
+set --
+for p in %s; do
+  if [ -e "$p" ]; then set -- "$@" -C "$(dirname "$p")" "$(basename "$p")"; else echo "required path not found: $p" >&2; missing=1; fi
+done
+for p in %s; do
+  if [ -e "$p" ]; then set -- "$@" -C "$(dirname "$p")" "$(basename "$p")"; else echo "optional path not found, skipping: $p" >&2; fi
+done
+[ "$missing" -eq 0 ] || exit 1
+[ "$#" -gt 0 ] || { echo "none of the requested paths exist" >&2; exit 1; }
+tar -czf "$archive" "$@"
+curl -sSf -X PUT -T "$archive" %s
+sha256sum "$archive" | cut -d ' ' -f 1`
+
+// CopyToEC2Instance copies the local files matching localPaths to remoteDir on an EC2 instance.
+// The files are archived relative to baseDir, staged in the S3 bucket under keyPrefix and downloaded on the
+// instance through a presigned URL by an SSM command, which verifies the checksum before extracting them.
+// The staged archive is deleted once the command completes. It returns the command ID and an error (if any).
+func CopyToEC2Instance(ctx context.Context, logger Logger, ssmClient SSMAPI, s3Client S3API, presignClient S3PresignAPI, ec2InstanceId string, localPaths []string, baseDir, bucket, keyPrefix, remoteDir string, ssmAgentMaxWaitTime, maxWaitTime int) (CommandId, error) {
+	archive, err := os.CreateTemp("", "ec2-runner-*.tar.gz")
+	if err != nil {
+		return "", fmt.Errorf("error creating archive: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	hash := sha256.New()
+	count, err := createArchive(io.MultiWriter(archive, hash), baseDir, localPaths)
+	if err != nil {
+		return "", fmt.Errorf("error creating archive: %w", err)
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("error reading archive: %w", err)
+	}
+
+	key := stagingKey(ctx, keyPrefix, ec2InstanceId)
+	putParams := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   archive,
+	}
+	if _, err := s3Client.PutObject(ctx, putParams); err != nil {
+		return "", fmt.Errorf("error uploading archive to s3://%s/%s: %w", bucket, key, err)
+	}
+	logger.Infof("Staged %d files in s3://%s/%s (sha256 %s)", count, bucket, key, checksum)
+	defer deleteStagingObject(logger, s3Client, bucket, key)
+
+	getParams := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	presigned, err := presignClient.PresignGetObject(ctx, getParams, s3.WithPresignExpires(presignExpiry(maxWaitTime)))
+	if err != nil {
+		return "", fmt.Errorf("error presigning download of s3://%s/%s: %w", bucket, key, err)
+	}
+	logger.AddMask(presigned.URL)
+
+	script := fmt.Sprintf(copyToInstanceScript, shellQuote(remoteDir), shellQuote(presigned.URL), checksum)
+	commandId, err := SendCommandToEC2Instance(ctx, logger, ssmClient, ec2InstanceId, script, ssmAgentMaxWaitTime)
+	if err != nil {
+		return "", err
+	}
+	if _, err := WaitForCommand(ctx, logger, ssmClient, ec2InstanceId, commandId, maxWaitTime); err != nil {
+		return commandId, err
+	}
+	logger.Infof("Copied %d files to %s on instance %s", count, remoteDir, ec2InstanceId)
+
+	return commandId, nil
+}
+
+// CopyFromEC2Instance copies remotePaths and any existing optionalPaths from an EC2 instance into localDir.
+// An SSM command archives the paths on the instance and uploads the archive through a presigned URL to the S3 bucket
+// under keyPrefix, from where it is downloaded, verified against the checksum reported by the command and extracted.
+// The command fails if any of remotePaths is missing. It returns the command ID and an error (if any).
+func CopyFromEC2Instance(ctx context.Context, logger Logger, ssmClient SSMAPI, s3Client S3API, presignClient S3PresignAPI, ec2InstanceId string, remotePaths, optionalPaths []string, localDir, bucket, keyPrefix string, ssmAgentMaxWaitTime, maxWaitTime int) (CommandId, error) {
+	key := stagingKey(ctx, keyPrefix, ec2InstanceId)
+	putParams := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	presigned, err := presignClient.PresignPutObject(ctx, putParams, s3.WithPresignExpires(presignExpiry(maxWaitTime)))
+	if err != nil {
+		return "", fmt.Errorf("error presigning upload to s3://%s/%s: %w", bucket, key, err)
+	}
+	logger.AddMask(presigned.URL)
+
+	script := fmt.Sprintf(copyFromInstanceScript, shellQuoteAll(remotePaths), shellQuoteAll(optionalPaths), shellQuote(presigned.URL))
+	commandId, err := SendCommandToEC2Instance(ctx, logger, ssmClient, ec2InstanceId, script, ssmAgentMaxWaitTime)
+	if err != nil {
+		return "", err
+	}
+	defer deleteStagingObject(logger, s3Client, bucket, key)
+
+	details, err := WaitForCommand(ctx, logger, ssmClient, ec2InstanceId, commandId, maxWaitTime)
+	if err != nil {
+		return commandId, fmt.Errorf("error archiving %s on instance %s: %w", strings.Join(remotePaths, ", "), ec2InstanceId, err)
+	}
+	checksum := lastLine(aws.ToString(details.StandardOutputContent))
+
+	archive, err := os.CreateTemp("", "ec2-runner-*.tar.gz")
+	if err != nil {
+		return commandId, fmt.Errorf("error creating archive: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	getParams := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	resp, err := s3Client.GetObject(ctx, getParams)
+	if err != nil {
+		return commandId, fmt.Errorf("error downloading archive from s3://%s/%s: %w", bucket, key, err)
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, hash), resp.Body); err != nil {
+		return commandId, fmt.Errorf("error downloading archive from s3://%s/%s: %w", bucket, key, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+		return commandId, fmt.Errorf("checksum mismatch for archive s3://%s/%s: instance reported %q, downloaded %s", bucket, key, checksum, sum)
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return commandId, fmt.Errorf("error reading archive: %w", err)
+	}
+
+	count, err := extractArchive(archive, localDir)
+	if err != nil {
+		return commandId, fmt.Errorf("error extracting archive into %s: %w", localDir, err)
+	}
+	logger.Infof("Copied %d files from instance %s to %s", count, ec2InstanceId, localDir)
+
+	return commandId, nil
+}
+
+// createArchive writes a gzipped tar archive of the files matching patterns to w.
+// Patterns are globs relative to baseDir; matching directories are added recursively and symlinks are skipped.
+// It returns the number of files archived, or an error if a pattern matches nothing or escapes baseDir.
+func createArchive(w io.Writer, baseDir string, patterns []string) (int, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	count := 0
+	for _, pattern := range patterns {
+		if !filepath.IsLocal(pattern) {
+			return 0, fmt.Errorf("path pattern %q must be relative to %s", pattern, baseDir)
+		}
+		matches, err := filepath.Glob(filepath.Join(baseDir, pattern))
+		if err != nil {
+			return 0, fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return 0, fmt.Errorf("no files match %q", pattern)
+		}
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				name, err := filepath.Rel(baseDir, p)
+				if err != nil || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+					return fmt.Errorf("%s is outside %s", p, baseDir)
+				}
+				if name == "." || (!d.Type().IsRegular() && !d.IsDir()) {
+					return nil
+				}
+				if err := addToArchive(tw, p, filepath.ToSlash(name), d); err != nil {
+					return err
+				}
+				if !d.IsDir() {
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return count, gz.Close()
+}
+
+// addToArchive adds a single file or directory entry to tw under name.
+func addToArchive(tw *tar.Writer, p, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if d.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if d.IsDir() {
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractArchive extracts the regular files and directories of a gzipped tar archive into destDir.
+// Other entry types are skipped. It returns the number of files extracted, or an error if an entry would
+// be written outside destDir.
+func extractArchive(r io.Reader, destDir string) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	count := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !filepath.IsLocal(header.Name) {
+			return 0, fmt.Errorf("archive entry %q is outside %s", header.Name, destDir)
+		}
+		target := filepath.Join(destDir, header.Name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return 0, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return 0, err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return 0, err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+}
+
+// deleteStagingObject removes a staged archive from S3. Failures are logged rather than returned
+// so they don't mask the result of the transfer.
+func deleteStagingObject(logger Logger, s3Client S3API, bucket, key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	deleteParams := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if _, err := s3Client.DeleteObject(ctx, deleteParams); err != nil {
+		logger.Warningf("error deleting staged archive s3://%s/%s: %v", bucket, key, err)
+	}
+}
+
+// stagingKey returns a unique S3 key for an archive transferred to or from an instance, named with the time of
+// the clock of ctx.
+func stagingKey(ctx context.Context, keyPrefix, ec2InstanceId string) string {
+	return path.Join(keyPrefix, fmt.Sprintf("%s-%d.tar.gz", ec2InstanceId, now(ctx).UnixNano()))
+}
+
+// presignExpiry returns how long a presigned URL must stay valid for a command that may wait maxWaitTime seconds.
+func presignExpiry(maxWaitTime int) time.Duration {
+	return time.Duration(maxWaitTime)*time.Second + time.Minute
+}
+
+// shellQuoteAll quotes each element of list and joins them with spaces.
+func shellQuoteAll(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = shellQuote(s)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// lastLine returns the last non-empty line of s with surrounding whitespace removed.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// shellQuote quotes s for safe use as a single word in a POSIX shell script.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
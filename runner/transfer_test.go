@@ -0,0 +1,291 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// MockS3Client is an in-memory stand-in for an S3 bucket.
+type MockS3Client struct {
+	objects map[string][]byte
+	// uploaded keeps every object ever put, including those deleted since.
+	uploaded map[string][]byte
+}
+
+func NewMockS3Client() *MockS3Client {
+	return &MockS3Client{objects: map[string][]byte{}, uploaded: map[string][]byte{}}
+}
+
+func (m *MockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	key := *params.Bucket + "/" + *params.Key
+	m.objects[key] = data
+	m.uploaded[key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *MockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	data, ok := m.objects[*params.Bucket+"/"+*params.Key]
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey: %s", *params.Key)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (m *MockS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	delete(m.objects, *params.Bucket+"/"+*params.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+type MockS3PresignClient struct {
+	// keys records the bucket and key of every presigned request.
+	keys []string
+}
+
+func (m *MockS3PresignClient) PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	m.keys = append(m.keys, *params.Bucket+"/"+*params.Key)
+	return &v4.PresignedHTTPRequest{
+		URL:    fmt.Sprintf("https://%s.s3.amazonaws.com/%s?X-Amz-Signature=test", *params.Bucket, *params.Key),
+		Method: "GET",
+	}, nil
+}
+
+func (m *MockS3PresignClient) PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	m.keys = append(m.keys, *params.Bucket+"/"+*params.Key)
+	return &v4.PresignedHTTPRequest{
+		URL:    fmt.Sprintf("https://%s.s3.amazonaws.com/%s?X-Amz-Signature=test", *params.Bucket, *params.Key),
+		Method: "PUT",
+	}, nil
+}
+
+// archiveNames returns the sorted entry names of a gzipped tar archive.
+func archiveNames(t *testing.T, data []byte) []string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected gzip archive, got %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected tar archive, got %v", err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCreateArchive(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"build/app":        "binary",
+		"build/lib/a.so":   "lib",
+		"config.yml":       "key: value",
+		"config.local.yml": "key: local",
+		"README.md":        "readme",
+	})
+
+	var buf bytes.Buffer
+	count, err := createArchive(&buf, dir, []string{"build", "config*.yml"})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 files, got %d", count)
+	}
+
+	got := strings.Join(archiveNames(t, buf.Bytes()), ",")
+	want := "build/,build/app,build/lib/,build/lib/a.so,config.local.yml,config.yml"
+	if got != want {
+		t.Fatalf("expected archive entries %s, got %s", want, got)
+	}
+
+	if _, err := createArchive(io.Discard, dir, []string{"missing/*"}); err == nil {
+		t.Fatalf("expected error for pattern matching no files")
+	}
+	if _, err := createArchive(io.Discard, dir, []string{"../*"}); err == nil {
+		t.Fatalf("expected error for pattern outside base directory")
+	}
+}
+
+func TestCopyToEC2Instance(t *testing.T) {
+	action := githubactions.New()
+	mockSSM := &MockSSMClient{}
+	mockS3 := NewMockS3Client()
+	dir := writeTestFiles(t, map[string]string{"dist/app.tar": "app"})
+
+	ctx := withClock(context.Background(), &fakeClock{now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)})
+
+	commandId, err := CopyToEC2Instance(ctx, action, mockSSM, mockS3, &MockS3PresignClient{}, testEC2ClientId, []string{"dist"}, dir, "bucket", "staging", "/opt/app", 60, 60)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if commandId != "command-id-123" {
+		t.Fatalf("expected command ID command-id-123, got %s", commandId)
+	}
+
+	if len(mockS3.uploaded) != 1 {
+		t.Fatalf("expected 1 staged archive, got %d", len(mockS3.uploaded))
+	}
+	if len(mockS3.objects) != 0 {
+		t.Fatalf("expected staged archive to be deleted, got %d objects", len(mockS3.objects))
+	}
+
+	for key, data := range mockS3.uploaded {
+		if want := "bucket/staging/" + testEC2ClientId + "-1717243200000000000.tar.gz"; key != want {
+			t.Fatalf("expected archive staged as %s, got %s", want, key)
+		}
+		sum := sha256.Sum256(data)
+		if len(mockSSM.commands) != 1 || !strings.Contains(mockSSM.commands[0], hex.EncodeToString(sum[:])) {
+			t.Fatalf("expected command to verify checksum %x, got %v", sum, mockSSM.commands)
+		}
+	}
+	if !strings.Contains(mockSSM.commands[0], "target='/opt/app'") {
+		t.Fatalf("expected command to extract to /opt/app, got %s", mockSSM.commands[0])
+	}
+}
+
+// RemoteShellSSMClient runs each command sent with run, which returns its standard output.
+type RemoteShellSSMClient struct {
+	MockSSMClient
+	run    func(command string) string
+	stdout string
+}
+
+func (m *RemoteShellSSMClient) SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	for _, command := range params.Parameters["commands"] {
+		m.stdout = m.run(command)
+	}
+	return m.MockSSMClient.SendCommand(ctx, params, optFns...)
+}
+
+func (m *RemoteShellSSMClient) GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	return commandInvocationOutput(ssmTypes.CommandInvocationStatusSuccess, 0, m.stdout, ""), nil
+}
+
+func TestCopyFromEC2Instance(t *testing.T) {
+	action := githubactions.New()
+	mockS3 := NewMockS3Client()
+	mockPresign := &MockS3PresignClient{}
+	remoteDir := writeTestFiles(t, map[string]string{"results/junit.xml": "<testsuites/>"})
+	localDir := t.TempDir()
+
+	// Simulate the instance archiving the requested paths and uploading them through the presigned URL.
+	mockSSM := &RemoteShellSSMClient{run: func(command string) string {
+		var buf bytes.Buffer
+		if _, err := createArchive(&buf, remoteDir, []string{"results"}); err != nil {
+			t.Fatal(err)
+		}
+		mockS3.objects[mockPresign.keys[0]] = buf.Bytes()
+		sum := sha256.Sum256(buf.Bytes())
+		return "some tar output\n" + hex.EncodeToString(sum[:]) + "\n"
+	}}
+
+	ctx := context.Background()
+
+	_, err := CopyFromEC2Instance(ctx, action, mockSSM, mockS3, mockPresign, testEC2ClientId, []string{"/opt/app/results"}, []string{"/opt/app/coverage.out"}, localDir, "bucket", "staging", 60, 60)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(localDir, "results", "junit.xml"))
+	if err != nil {
+		t.Fatalf("expected copied file, got %s", err)
+	}
+	if string(data) != "<testsuites/>" {
+		t.Fatalf("expected copied file content <testsuites/>, got %s", data)
+	}
+	if len(mockS3.objects) != 0 {
+		t.Fatalf("expected staged archive to be deleted, got %d objects", len(mockS3.objects))
+	}
+	if !strings.Contains(mockSSM.commands[0], "'/opt/app/results'") || !strings.Contains(mockSSM.commands[0], "'/opt/app/coverage.out'") {
+		t.Fatalf("expected command to archive the requested paths, got %s", mockSSM.commands[0])
+	}
+}
+
+func TestCopyFromEC2InstanceChecksumMismatch(t *testing.T) {
+	action := githubactions.New()
+	mockS3 := NewMockS3Client()
+	mockPresign := &MockS3PresignClient{}
+	remoteDir := writeTestFiles(t, map[string]string{"coverage.out": "mode: set"})
+
+	// The archive uploaded doesn't match the checksum printed.
+	mockSSM := &RemoteShellSSMClient{run: func(command string) string {
+		var buf bytes.Buffer
+		if _, err := createArchive(&buf, remoteDir, []string{"coverage.out"}); err != nil {
+			t.Fatal(err)
+		}
+		mockS3.objects[mockPresign.keys[0]] = buf.Bytes()
+		return strings.Repeat("0", 64)
+	}}
+
+	ctx := context.Background()
+
+	_, err := CopyFromEC2Instance(ctx, action, mockSSM, mockS3, mockPresign, testEC2ClientId, []string{"/opt/app/coverage.out"}, nil, t.TempDir(), "bucket", "staging", 60, 60)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch error, got %v", err)
+	}
+}
+
+func TestExtractArchiveOutsideDestination(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0o644, Size: 1, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("x"))
+	tw.Close()
+	gz.Close()
+
+	if _, err := extractArchive(&buf, t.TempDir()); err == nil {
+		t.Fatalf("expected error for archive entry outside destination")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	got := shellQuote("it's here")
+	want := `'it'\''s here'`
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}